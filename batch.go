@@ -0,0 +1,129 @@
+package rss
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BatchOptions controls how ParseFeedsFromDir works.
+type BatchOptions struct {
+	// Concurrency is how many files we parse at once. If it is less than 1 we
+	// parse one file at a time.
+	Concurrency int
+
+	// StopOnError controls whether we stop parsing once a file fails. By default
+	// we continue and parse every file. When we stop, files already being
+	// parsed still finish, so there may be more than one error.
+	StopOnError bool
+
+	// Retries is how many more times we try to read a file if reading it fails,
+	// such as because of a transient I/O error. We wait RetryDelay between
+	// tries. We don't retry files we read but couldn't parse, as they would
+	// fail the same way again. A file that still fails is skipped, and its
+	// last error recorded.
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// readFile reads a file. It is a variable so tests can make reads fail.
+var readFile = ioutil.ReadFile
+
+// ParseFeedsFromDir parses every regular file in a directory as a feed. This
+// is useful for reprocessing stored feeds.
+//
+// It returns the feeds that parsed successfully and the errors for those that
+// did not, both keyed by filename (without the directory). Subdirectories are
+// skipped.
+//
+// If we can't read the directory at all, the error map holds a single error
+// keyed by dir.
+//
+// With a Concurrency above 1 we parse files in several goroutines at once, so
+// the OnItemError setting's function may be called concurrently. It must be
+// safe for concurrent use.
+func ParseFeedsFromDir(dir string, opts BatchOptions) (map[string]*Feed,
+	map[string]error) {
+	feeds := map[string]*Feed{}
+	errs := map[string]error{}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		errs[dir] = errors.Wrap(err, "error reading directory")
+		return feeds, errs
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	names := make(chan string)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	stopped := false
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				feed, err := parseFeedFile(filepath.Join(dir, name), opts)
+
+				mutex.Lock()
+				if err != nil {
+					errs[name] = err
+					if opts.StopOnError {
+						stopped = true
+					}
+				} else {
+					feeds[name] = feed
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+
+		mutex.Lock()
+		stop := stopped
+		mutex.Unlock()
+		if stop {
+			break
+		}
+
+		names <- file.Name()
+	}
+
+	close(names)
+	wg.Wait()
+
+	return feeds, errs
+}
+
+// parseFeedFile reads a file and parses it as a feed. If reading fails, we
+// retry as opts says.
+func parseFeedFile(filename string, opts BatchOptions) (*Feed, error) {
+	buf, err := readFile(filename)
+	for i := 0; err != nil && i < opts.Retries; i++ {
+		time.Sleep(opts.RetryDelay)
+		buf, err = readFile(filename)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading file")
+	}
+
+	feed, err := ParseFeedXML(buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing feed")
+	}
+
+	return feed, nil
+}
@@ -0,0 +1,52 @@
+package rss
+
+import (
+	"encoding/csv"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WriteCSV writes the feed's items as CSV, one row per item. This is handy for
+// looking at a feed in a spreadsheet.
+//
+// The first row is a header. The columns are title, link, pubDate, guid, and
+// description. pubDate is in RFC 3339 format, or blank if the item has no
+// date. description is the item's description converted to plain text.
+//
+// Fields are quoted as RFC 4180 describes, and lines end with CRLF.
+func (f *Feed) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+
+	if err := cw.Write([]string{
+		"title", "link", "pubDate", "guid", "description",
+	}); err != nil {
+		return errors.Wrap(err, "error writing header")
+	}
+
+	for _, item := range f.Items {
+		pubDate := ""
+		if !item.PubDate.IsZero() {
+			pubDate = item.PubDate.Format(time.RFC3339)
+		}
+
+		if err := cw.Write([]string{
+			item.Title,
+			item.Link,
+			pubDate,
+			item.GUID,
+			plaintext(item.Description),
+		}); err != nil {
+			return errors.Wrap(err, "error writing item")
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return errors.Wrap(err, "error flushing CSV")
+	}
+
+	return nil
+}
@@ -2,17 +2,39 @@ package rss
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 
 	"golang.org/x/net/html/charset"
 )
 
+// itunesNS is the namespace for Apple's podcast elements.
+const itunesNS = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+
+// atomNS is the namespace for Atom elements.
+const atomNS = "http://www.w3.org/2005/Atom"
+
+// contentNS is the namespace for the content module's elements, such as
+// <content:encoded>.
+const contentNS = "http://purl.org/rss/1.0/modules/content/"
+
+// rss1NS is the namespace for RSS 1.0 (RDF) elements.
+const rss1NS = "http://purl.org/rss/1.0/"
+
 // rssXML is used for parsing/encoding RSS.
 type rssXML struct {
 	// If xml.Name is specified and has a tag name, we must have this element as
@@ -31,17 +53,260 @@ type rssChannelXML struct {
 	Description string       `xml:"description"`
 	PubDate     string       `xml:"pubDate"`
 	Items       []rssItemXML `xml:"item"`
+
+	LastBuildDate string `xml:"lastBuildDate"`
+
+	// How many minutes the channel may be cached for. Optional.
+	TTL string `xml:"ttl"`
+
+	// Use the default namespace so we don't match <itunes:category>.
+	Categories []rssCategoryXML `xml:"default category"`
+
+	// Use the default namespace so we don't match <admin:generatorAgent> or
+	// similar.
+	Generator string `xml:"default generator"`
+
+	ManagingEditor string `xml:"managingEditor"`
+
+	// Use the default namespace so we can tell <dc:language> apart. Some feeds
+	// use it instead.
+	Language   string `xml:"default language"`
+	DCLanguage string `xml:"http://purl.org/dc/elements/1.1/ language"`
+
+	// Use the default namespace so we don't match <media:copyright> or
+	// similar. Some feeds use <dc:rights> instead.
+	Copyright string `xml:"default copyright"`
+	DCRights  string `xml:"http://purl.org/dc/elements/1.1/ rights"`
+
+	// Use the default namespace so we don't match <itunes:image> or similar.
+	Image *rssImageXML `xml:"default image"`
+
+	// Many RSS feeds include Atom links, such as one with rel=self.
+	AtomLinks []atomLink `xml:"http://www.w3.org/2005/Atom link"`
+
+	// Some feeds use the Atom author instead of <managingEditor>.
+	AtomAuthor *atomPersonXML `xml:"http://www.w3.org/2005/Atom author"`
+
+	syndicationXML
+
+	ITunesOwner *itunesOwnerXML `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd owner"`
+	ITunesType  string          `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd type"`
+	ITunesImage *itunesImageXML `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+
+	ITunesCategories []itunesCategoryXML `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd category"`
+
+	Extensions []extensionXML `xml:",any"`
+}
+
+// syndicationXML holds elements from the syndication module
+// (http://purl.org/rss/1.0/modules/syndication/). The channel types of each
+// format embed it.
+type syndicationXML struct {
+	UpdatePeriod    string `xml:"http://purl.org/rss/1.0/modules/syndication/ updatePeriod"`
+	UpdateFrequency string `xml:"http://purl.org/rss/1.0/modules/syndication/ updateFrequency"`
+}
+
+// rssEnclosureXML is <enclosure>.
+type rssEnclosureXML struct {
+	URL    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// rssGUIDXML is <guid>.
+type rssGUIDXML struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// isPermaLink returns the isPermaLink attribute, or nil if it is absent or
+// not a boolean.
+func (g rssGUIDXML) isPermaLink() *bool {
+	isPermaLink, err := strconv.ParseBool(strings.TrimSpace(g.IsPermaLink))
+	if err != nil {
+		return nil
+	}
+	return &isPermaLink
+}
+
+// rssImageXML is <image>. RDF uses the same elements.
+type rssImageXML struct {
+	URL         string `xml:"url"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	Width       string `xml:"width"`
+	Height      string `xml:"height"`
+}
+
+// toImage converts the <image> element to an Image. It returns nil if there
+// is no element.
+func (i *rssImageXML) toImage() *Image {
+	if i == nil {
+		return nil
+	}
+	return &Image{
+		URL:         strings.TrimSpace(i.URL),
+		Title:       strings.TrimSpace(i.Title),
+		Link:        strings.TrimSpace(i.Link),
+		Description: strings.TrimSpace(i.Description),
+		Width:       parseCount(i.Width),
+		Height:      parseCount(i.Height),
+	}
+}
+
+// rssCategoryXML is <category>.
+type rssCategoryXML struct {
+	Domain string `xml:"domain,attr"`
+	Name   string `xml:",chardata"`
+}
+
+// atomCategoryXML is Atom's <category term="..." scheme="..."/>.
+type atomCategoryXML struct {
+	Term   string `xml:"term,attr"`
+	Scheme string `xml:"scheme,attr"`
+}
+
+// itunesOwnerXML is <itunes:owner>.
+type itunesOwnerXML struct {
+	Name  string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd name"`
+	Email string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd email"`
 }
 
 // rssItemXML is used for parsing/encoding RSS.
 type rssItemXML struct {
-	XMLName     xml.Name `xml:"item"`
-	Title       string   `xml:"title"`
-	Link        string   `xml:"link"`
-	Description string   `xml:"description"`
-	PubDate     string   `xml:"pubDate"`
+	XMLName xml.Name `xml:"item"`
+
+	// Raw is the item's XML, which we give to OnItemError. The decoder always
+	// fills it in, so we hold a second copy of each item's bytes while parsing
+	// even if there is no OnItemError.
+	Raw string `xml:",innerxml"`
+
+	Title string `xml:"title"`
+	// Use the default namespace so we don't match <atom:link>.
+	Link        string `xml:"default link"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
 	// GUID is optional. Unique identifier.
-	GUID string `xml:"guid"`
+	GUID rssGUIDXML `xml:"guid"`
+
+	// Use the default namespace so we don't match <itunes:author> or
+	// <atom:author>.
+	Author string `xml:"default author"`
+
+	// Many feeds, such as WordPress ones, use this instead of <author>.
+	DCCreator string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+
+	// Some feeds use the Dublin Core date instead of, or as well as, pubDate.
+	DCDate string `xml:"http://purl.org/dc/elements/1.1/ date"`
+
+	// Copyright of the item if it differs from the channel's. Optional.
+	DCRights string `xml:"http://purl.org/dc/elements/1.1/ rights"`
+
+	// Full content. Optional.
+	ContentEncoded string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+
+	// Use the default namespace so we don't match <itunes:category>.
+	Categories []rssCategoryXML `xml:"default category"`
+
+	// Media files. Optional.
+	Enclosures []rssEnclosureXML `xml:"enclosure"`
+
+	// Media RSS objects, either on their own or grouped as renditions.
+	MediaContents []mediaContentXML `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaGroups   []mediaGroupXML   `xml:"http://search.yahoo.com/mrss/ group"`
+
+	// Content advisories.
+	MediaRatings   []mediaRatingXML `xml:"http://search.yahoo.com/mrss/ rating"`
+	ITunesExplicit string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+	ITunesImage    *itunesImageXML  `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	ITunesAuthor   string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+	ITunesDuration string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	ITunesEpisode  string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	ITunesSeason   string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season"`
+
+	// Some RSS feeds include Atom elements in their items. We use them if the
+	// RSS equivalent is missing.
+	AtomLinks   []atomLink     `xml:"http://www.w3.org/2005/Atom link"`
+	AtomAuthor  *atomPersonXML `xml:"http://www.w3.org/2005/Atom author"`
+	AtomSummary atomTextXML    `xml:"http://www.w3.org/2005/Atom summary"`
+
+	PodcastTranscripts []podcastLinkXML `xml:"https://podcastindex.org/namespace/1.0 transcript"`
+	PodcastChapters    *podcastLinkXML  `xml:"https://podcastindex.org/namespace/1.0 chapters"`
+
+	commentsXML
+
+	Extensions []extensionXML `xml:",any"`
+}
+
+// itunesCategoryXML is <itunes:category>. It may contain subcategories.
+type itunesCategoryXML struct {
+	Text          string              `xml:"text,attr"`
+	Subcategories []itunesCategoryXML `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd category"`
+}
+
+// itunesImageXML is <itunes:image>.
+type itunesImageXML struct {
+	Href string `xml:"href,attr"`
+}
+
+// url returns the image's URL, or blank if there is none.
+func (i *itunesImageXML) url() string {
+	if i == nil {
+		return ""
+	}
+	return strings.TrimSpace(i.Href)
+}
+
+// mediaRatingXML is <media:rating> from Media RSS.
+type mediaRatingXML struct {
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// mediaContentXML is <media:content> from Media RSS.
+type mediaContentXML struct {
+	URL       string `xml:"url,attr"`
+	FileSize  string `xml:"fileSize,attr"`
+	Type      string `xml:"type,attr"`
+	Medium    string `xml:"medium,attr"`
+	IsDefault string `xml:"isDefault,attr"`
+	Bitrate   string `xml:"bitrate,attr"`
+	Duration  string `xml:"duration,attr"`
+	Width     string `xml:"width,attr"`
+	Height    string `xml:"height,attr"`
+}
+
+// mediaGroupXML is <media:group> from Media RSS.
+type mediaGroupXML struct {
+	Contents []mediaContentXML `xml:"http://search.yahoo.com/mrss/ content"`
+}
+
+// podcastLinkXML is an element from the Podcasting 2.0 namespace that links to
+// a file, such as <podcast:transcript>.
+type podcastLinkXML struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// commentsXML holds elements about an item's comments. The item types of each
+// format embed it.
+type commentsXML struct {
+	SlashSection    string `xml:"http://purl.org/rss/1.0/modules/slash/ section"`
+	SlashDepartment string `xml:"http://purl.org/rss/1.0/modules/slash/ department"`
+	SlashComments   string `xml:"http://purl.org/rss/1.0/modules/slash/ comments"`
+	SlashHitParade  string `xml:"http://purl.org/rss/1.0/modules/slash/ hit_parade"`
+
+	ThreadTotal string `xml:"http://purl.org/syndication/thread/1.0 total"`
+
+	WFWCommentRSS string `xml:"http://wellformedweb.org/CommentAPI/ commentRss"`
+}
+
+// extensionXML is any element we don't otherwise parse.
+type extensionXML struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Value   string     `xml:",chardata"`
 }
 
 // rdfXML is used for parsing RDF.
@@ -51,27 +316,110 @@ type rdfXML struct {
 
 	Channel rdfChannelXML `xml:"channel"`
 
+	// The channel's artwork. This is outside of the channel, which refers to it
+	// with <image rdf:resource="..."/>.
+	Image *rssImageXML `xml:"image"`
+
 	RDFItems []rdfItemXML `xml:"item"`
 }
 
 // rdfChannelXML is part of parsing RDF.
 type rdfChannelXML struct {
-	XMLName     xml.Name `xml:"channel"`
-	Title       string   `xml:"title"`
+	XMLName xml.Name `xml:"channel"`
+	Title   string   `xml:"title"`
+
+	// Feeds may include Atom links (such as rel=self) in the channel. This must
+	// come before Links as otherwise Links would match them too.
+	AtomLinks []atomLink `xml:"http://www.w3.org/2005/Atom link"`
+
 	Links       []string `xml:"link"`
 	Description string   `xml:"description"`
 	PubDate     string   `xml:"date"`
+	DCRights    string   `xml:"http://purl.org/dc/elements/1.1/ rights"`
+	DCSubjects  []string `xml:"http://purl.org/dc/elements/1.1/ subject"`
+	DCLanguage  string   `xml:"http://purl.org/dc/elements/1.1/ language"`
+
+	// <image rdf:resource="..."/> refers to the <image> outside the channel.
+	Image rdfResourceXML `xml:"image"`
+
+	syndicationXML
+
+	Extensions []extensionXML `xml:",any"`
+}
+
+// rdfResourceXML is an element that refers to another, such as
+// <image rdf:resource="..."/>.
+type rdfResourceXML struct {
+	Resource string `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# resource,attr"`
 }
 
 // rdfItemXML is used for parsing <rdf> item XML.
 type rdfItemXML struct {
-	XMLName     xml.Name `xml:"item"`
-	Title       string   `xml:"title"`
-	Link        string   `xml:"link"`
-	Description string   `xml:"description"`
-	PubDate     string   `xml:"date"`
-	// RDF doesn't have a unique identifier like guid/id? Or maybe it does, but
-	// the only feed I have using RDF doesn't use it, so I'm not looking too hard!
+	XMLName xml.Name `xml:"item"`
+
+	// Raw is the item's XML. See rssItemXML.
+	Raw string `xml:",innerxml"`
+
+	Title       string `xml:"title"`
+	Description string `xml:"description"`
+
+	// Usually there is one <link>, but some items also have a namespaced link
+	// such as <atom:link href="..."/>. See rdfItemLink().
+	Links []rdfLinkXML `xml:"link"`
+
+	// Items usually have <dc:date>. Some have <pubDate> instead, as in RSS.
+	DCDate  string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	PubDate string `xml:"pubDate"`
+
+	// Full content. Optional.
+	ContentEncoded string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+
+	DCCreator string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+
+	// RDF doesn't have a unique identifier like guid/id, but some items have
+	// <dc:identifier>. It may differ from the link.
+	DCIdentifier string `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+
+	DCRights string `xml:"http://purl.org/dc/elements/1.1/ rights"`
+
+	// RDF has no <category>. Items use <dc:subject> instead.
+	DCSubjects []string `xml:"http://purl.org/dc/elements/1.1/ subject"`
+
+	commentsXML
+
+	Extensions []extensionXML `xml:",any"`
+}
+
+// rdfLinkXML is a <link> in an RDF item. It may be from any namespace.
+type rdfLinkXML struct {
+	XMLName xml.Name
+	Href    string `xml:"href,attr"`
+	Value   string `xml:",chardata"`
+}
+
+// rdfItemLink picks an RDF item's link. We prefer the plain <link>. If it is
+// missing or blank, we use a namespaced one, taking its href attribute or its
+// text.
+func rdfItemLink(links []rdfLinkXML) string {
+	for _, l := range links {
+		if l.XMLName.Space != rss1NS && l.XMLName.Space != "default" {
+			continue
+		}
+		if link := strings.TrimSpace(l.Value); link != "" {
+			return link
+		}
+	}
+
+	for _, l := range links {
+		if href := strings.TrimSpace(l.Href); href != "" {
+			return href
+		}
+		if link := strings.TrimSpace(l.Value); link != "" {
+			return link
+		}
+	}
+
+	return ""
 }
 
 // atomXML describes an Atom feed. We use it for parsing. See
@@ -80,133 +428,1280 @@ type atomXML struct {
 	// The element name. Enforce it is atom:feed
 	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
 
+	// Base is the URL relative URLs in the feed are relative to. Optional.
+	Base string `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
+
+	// Lang is the language of the feed. Optional.
+	Lang string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+
 	// Title is human readable. It must be present.
-	Title string `xml:"title"`
+	Title atomTextXML `xml:"title"`
 
 	// Web resource. Zero or more. Feeds should contain with with rel=self.
 	Links []atomLink `xml:"link"`
 
+	// Human readable description. Optional.
+	Subtitle atomTextXML `xml:"subtitle"`
+
 	// Last time feed was updated.
 	Updated string `xml:"updated"`
 
+	// ID is required. Unique identifier.
+	ID string `xml:"id"`
+
+	// Software that made the feed. Optional.
+	Generator *atomGeneratorXML `xml:"generator"`
+
+	// Logo is a URL to a large image for the feed, and icon to a small one such
+	// as a favicon. Both are optional.
+	Logo string `xml:"logo"`
+	Icon string `xml:"icon"`
+
+	// Person responsible for the feed. Required unless every entry has one.
+	Author *atomPersonXML `xml:"author"`
+
+	// Copyright notice. Optional.
+	Rights atomTextXML `xml:"rights"`
+
+	Categories []atomCategoryXML `xml:"category"`
+
 	Items []atomItemXML `xml:"entry"`
+
+	syndicationXML
+
+	Extensions []extensionXML `xml:",any"`
+}
+
+// atomGeneratorXML describes a <generator> element.
+type atomGeneratorXML struct {
+	URI     string `xml:"uri,attr"`
+	Version string `xml:"version,attr"`
+	Name    string `xml:",chardata"`
+}
+
+// atomPersonXML describes a person construct such as <author>.
+type atomPersonXML struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email"`
+	URI   string `xml:"uri"`
+}
+
+// name returns the person's name, or blank if there is none.
+func (p *atomPersonXML) name() string {
+	if p == nil {
+		return ""
+	}
+	return strings.TrimSpace(p.Name)
+}
+
+// atomLink describes a <link> element.
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+
+	// Enclosure links may say the type and size of what they link to.
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+// atomTextXML describes an element holding an Atom text construct, such as
+// <subtitle> or <content>. Its type attribute says what the text is: text,
+// html (escaped markup), or xhtml (markup inside a <div>).
+type atomTextXML struct {
+	Type     string `xml:"type,attr"`
+	Value    string `xml:",chardata"`
+	InnerXML string `xml:",innerxml"`
+}
+
+// plain returns the text as plain text. html and xhtml text may hold markup,
+// which we remove. We also collapse whitespace.
+func (a atomTextXML) plain() string {
+	if a.Type == "html" || a.Type == "xhtml" {
+		return plaintext(a.String())
+	}
+	return collapseSpace(a.Value)
+}
+
+// contentType returns the text's type. It is text if the element doesn't
+// say, as Atom specifies.
+func (a atomTextXML) contentType() string {
+	if t := strings.ToLower(strings.TrimSpace(a.Type)); t != "" {
+		return t
+	}
+	return "text"
+}
+
+// html returns the text as HTML. Unlike String(), we escape text so that any
+// < or & in it stays text.
+func (a atomTextXML) html() string {
+	if a.contentType() == "text" {
+		return html.EscapeString(a.Value)
+	}
+	return a.String()
+}
+
+// String returns the text as HTML. For html the decoder already unescaped the
+// markup. For xhtml we take the markup inside the wrapping <div>.
+func (a atomTextXML) String() string {
+	if a.Type != "xhtml" {
+		return a.Value
+	}
+
+	inner := strings.TrimSpace(a.InnerXML)
+	start := strings.Index(inner, ">")
+	end := strings.LastIndex(inner, "</")
+	if !strings.HasPrefix(inner, "<") || start == -1 || end < start {
+		return inner
+	}
+	return strings.TrimSpace(inner[start+1 : end])
+}
+
+// atomSourceXML describes an entry's <source>. It may contain any of the
+// feed's metadata elements, but we only look at a few.
+type atomSourceXML struct {
+	Title string     `xml:"title"`
+	ID    string     `xml:"id"`
+	Links []atomLink `xml:"link"`
+}
+
+// atomItemXML describes an item/entry in the feed. Atom calls these entries,
+// but for consistency with other formats I support, I call them items.
+type atomItemXML struct {
+	// Raw is the entry's XML. See rssItemXML.
+	Raw string `xml:",innerxml"`
+
+	// Base is the URL relative URLs in the entry are relative to. If it is
+	// relative itself, it is relative to the feed's. Optional.
+	Base string `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
+
+	// Human readable title. Must be present.
+	Title atomTextXML `xml:"title"`
+
+	// Web resource. Zero or more.
+	Links []atomLink `xml:"link"`
+
+	// Last time entry updated. Must be present.
+	Updated string `xml:"updated"`
+
+	// Content is optional.
+	Content atomTextXML `xml:"content"`
+
+	// Summary is a short version of the content. It is optional.
+	Summary atomTextXML `xml:"summary"`
+
+	// ID is required. Unique identifier.
+	ID string `xml:"id"`
+
+	// Person who wrote the entry. Optional if the feed has one.
+	Author *atomPersonXML `xml:"author"`
+
+	// Copyright notice if it differs from the feed's. Optional.
+	Rights atomTextXML `xml:"rights"`
+
+	Categories []atomCategoryXML `xml:"category"`
+
+	// Source is optional. It holds metadata about the feed the entry came from
+	// if it was copied from another feed.
+	Source *atomSourceXML `xml:"source"`
+
+	commentsXML
+
+	Extensions []extensionXML `xml:",any"`
+}
+
+// ParseFeedXML takes a feed's raw XML and returns a struct describing the feed.
+//
+// We support various formats: RSS, RDF, Atom. We try our best to decode the
+// feed in one of them. Despite the name, we also support JSON Feed. If the
+// document starts with '{' we parse it as that.
+//
+// The document may start with a byte order mark and whitespace. We decode it
+// using the encoding named in its XML declaration, if any.
+//
+// We resolve relative URLs against the feed's self link if it has one. See
+// ParseFeedXMLWithBase() to give a base URL yourself.
+func ParseFeedXML(data []byte) (*Feed, error) {
+	feed, _, err := ParseFeedXMLRaw(data)
+	return feed, err
+}
+
+// ParseFeedReader is like ParseFeedXML except it reads the feed from r. This
+// lets you pass an HTTP response body directly.
+//
+// We need the whole document to try each format, so we read all of r before
+// parsing. If r might be huge, wrap it in an io.LimitedReader.
+func ParseFeedReader(r io.Reader) (*Feed, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading feed")
+	}
+	return ParseFeedXML(data)
+}
+
+// ParseFeedXMLRaw is like ParseFeedXML except it also returns the bytes we
+// decoded. This lets you store a canonical copy of the feed alongside the
+// parsed version.
+//
+// The bytes are the input after this normalization:
+//
+// 1. We remove any UTF-8 byte order mark.
+//
+// 2. We remove whitespace before the first element or XML declaration.
+//
+// 3. If the XML declaration says the document is UTF-8, we replace invalid
+// UTF-8 sequences with U+FFFD (the replacement character).
+//
+// The returned bytes may share memory with data.
+//
+// If the Scrape setting is on and the document is not a feed we can parse, we
+// try to scrape it as HTML. In that case the returned bytes are the input
+// as is.
+func ParseFeedXMLRaw(data []byte) (*Feed, []byte, error) {
+	return parseFeed(context.Background(), data, "")
+}
+
+// ParseFeedXMLWithBase is like ParseFeedXML except we resolve relative URLs
+// against baseURL. Usually this is the URL you fetched the feed from.
+//
+// ParseFeedXML resolves relative URLs too, against the feed's self link if
+// it has one. Here we use baseURL instead. In either case, xml:base
+// attributes in Atom feeds take precedence, and we leave absolute URLs as
+// they are. We resolve the feed's link, self link, next page link, hubs, and
+// image, and each item's link, enclosures, and image.
+func ParseFeedXMLWithBase(data []byte, baseURL string) (*Feed, error) {
+	feed, _, err := parseFeed(context.Background(), data, baseURL)
+	return feed, err
+}
+
+// ParseFeedXMLContext is like ParseFeedXML except it stops if ctx is done.
+// This bounds how long a pathological document can take to parse, which is
+// useful if you parse untrusted feeds while serving requests.
+//
+// We check ctx periodically while decoding. If it is done, we stop and return
+// ctx.Err().
+func ParseFeedXMLContext(ctx context.Context, data []byte) (*Feed, error) {
+	feed, _, err := parseFeed(ctx, data, "")
+	return feed, err
+}
+
+// parseFeed does the work of ParseFeedXMLRaw(), ParseFeedXMLContext(), and
+// ParseFeedXMLWithBase(). baseURL may be blank.
+func parseFeed(ctx context.Context, data []byte, baseURL string) (*Feed,
+	[]byte, error) {
+	feed, normalized, err := parseFeedXMLRaw(ctx, data)
+	if err == nil {
+		resolveRelativeURLs(feed, baseURL)
+	}
+	if err == nil || !config.Scrape || ctx.Err() != nil {
+		return feed, normalized, err
+	}
+
+	scraped, scrapeErr := scrapeHTML(data)
+	if scrapeErr != nil {
+		return nil, nil, fmt.Errorf("%s, and unable to scrape as HTML (%s)", err,
+			scrapeErr)
+	}
+
+	if config.Verbose {
+		log.Printf("Scraped HTML document [%s]", scraped.Title)
+	}
+
+	resolveRelativeURLs(scraped, baseURL)
+
+	return scraped, data, nil
+}
+
+// parseJSONFeed parses data as a JSON Feed. The document may start with a byte
+// order mark and whitespace. We return the document without them.
+func parseJSONFeed(data []byte) (*Feed, []byte, error) {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(data, utf8BOM), " \t\r\n")
+	feed, err := parseAsJSONFeed(trimmed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse as JSON Feed (%s)", err)
+	}
+	return feed, trimmed, nil
+}
+
+// parseFeedXMLRaw does the work of ParseFeedXMLRaw() except for scraping.
+func parseFeedXMLRaw(ctx context.Context, data []byte) (*Feed, []byte,
+	error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if DetectFormat(data) == "JSON" {
+		return parseJSONFeed(data)
+	}
+
+	data, err := normalizeFeedXML(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// If ctx is done, decoding fails with its error. Return that rather than
+	// trying the next format.
+
+	channelRSS, errRSS := parseAsRSS(ctx, data)
+	if errRSS == nil {
+		return channelRSS, data, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	channelRDF, errRDF := parseAsRDF(ctx, data)
+	if errRDF == nil {
+		return channelRDF, data, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	channelAtom, errAtom := parseAsAtom(ctx, data)
+	if errAtom == nil {
+		return channelAtom, data, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if config.Lenient {
+		if feed, err := salvageFeed(ctx, data); err == nil {
+			return feed, data, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return nil, nil, fmt.Errorf(
+		"unable to parse as RSS (%s), RDF (%s), or Atom (%s)", errRSS, errRDF,
+		errAtom)
+}
+
+// DetectFormat looks at the start of a document to guess what format it is.
+// It returns RSS, RDF, Atom, or JSON (for JSON Feed), matching Feed.Type. It
+// returns blank if it can't tell.
+//
+// This is cheaper than parsing, but it only looks at the root element, so the
+// document might still fail to parse.
+func DetectFormat(data []byte) string {
+	data = bytes.TrimLeft(bytes.TrimPrefix(data, utf8BOM), " \t\r\n")
+	if bytes.HasPrefix(data, []byte("{")) {
+		return "JSON"
+	}
+
+	feedType, err := DetectFeedType(data)
+	if err != nil {
+		return ""
+	}
+	return feedType
+}
+
+// DetectFeedType looks at the root element of an XML document to tell what
+// kind of feed it is. It returns RSS, RDF, or Atom, matching Feed.Type.
+//
+// It returns an error if the document isn't XML or its root element isn't one
+// for a feed. This is useful to say why a document isn't a feed without
+// parsing it all. Since we only look at the root element, a document we
+// accept might still fail to parse.
+func DetectFeedType(data []byte) (string, error) {
+	d := newDecoder(context.Background(),
+		bytes.TrimLeft(bytes.TrimPrefix(data, utf8BOM), " \t\r\n"))
+	for {
+		token, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return "", errors.New("document has no root element")
+			}
+			return "", errors.Wrap(err, "error decoding token")
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		return feedTypeOfRoot(start.Name)
+	}
+}
+
+// feedTypeOfRoot decides the type of a feed from the name of its root
+// element. We check it the same way the parsers do: case insensitively for
+// RSS and RDF, and for Atom, it must be <feed> in the Atom namespace.
+func feedTypeOfRoot(name xml.Name) (string, error) {
+	switch strings.ToLower(name.Local) {
+	case "rss":
+		return "RSS", nil
+	case "rdf":
+		return "RDF", nil
+	}
+	if name.Local == "feed" && name.Space == atomNS {
+		return "Atom", nil
+	}
+	if name.Space != "" && name.Space != "default" {
+		return "", fmt.Errorf("root element <%s> in namespace %s is not a feed",
+			name.Local, name.Space)
+	}
+	return "", fmt.Errorf("root element <%s> is not a feed", name.Local)
+}
+
+// utf8BOM is the UTF-8 encoding of the byte order mark.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// normalizeFeedXML cleans up a document before we decode it. See
+// ParseFeedXMLRaw() for what we do.
+func normalizeFeedXML(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(data, utf8BOM)
+	data = bytes.TrimLeft(data, " \t\r\n")
+
+	// Hack. Strip invalid UTF-8 before trying to decode. We don't do this in all
+	// cases as we might not have UTF-8 yet.
+	d := newDecoder(context.Background(), data)
+	token, err := d.Token()
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding token")
+	}
+	if procInst, ok := token.(xml.ProcInst); ok {
+		inst := bytes.ToLower(procInst.Inst)
+		if bytes.Contains(inst, []byte("utf-8")) {
+			data = bytes.ToValidUTF8(data, []byte("\uFFFD"))
+		}
+	}
+
+	return data, nil
+}
+
+// parseAsRSS attempts to parse the buffer as if it contains an RSS feed.
+func parseAsRSS(ctx context.Context, data []byte) (*Feed, error) {
+	rssXML := rssXML{}
+	if err := newDecoder(ctx, data).Decode(&rssXML); err != nil {
+		return nil, fmt.Errorf("RSS XML decode error: %v", err)
+	}
+
+	if strings.ToLower(rssXML.XMLName.Local) != "rss" {
+		return nil, errors.New("base tag is not RSS")
+	}
+
+	feed := newRSSFeed(&rssXML)
+
+	if config.Verbose {
+		log.Printf("Parsed channel as RSS [%s]", feed.Title)
+	}
+
+	for _, item := range rssXML.Channel.Items {
+		feed.Items = append(feed.Items, rssItem(feed, item))
+	}
+
+	finishFeed(feed)
+
+	return feed, nil
+}
+
+// newRSSFeed builds a feed from an RSS channel's metadata. It doesn't look at
+// the channel's items.
+func newRSSFeed(rssXML *rssXML) *Feed {
+	// Build a channel struct now. It's common to the base formats we support.
+
+	feed := &Feed{
+		Title:       rssXML.Channel.Title,
+		Link:        rssXML.Channel.Link,
+		Self:        atomLinkHref(rssXML.Channel.AtomLinks, "self"),
+		NextPageURL: atomLinkHref(rssXML.Channel.AtomLinks, "next"),
+		Hubs:        atomLinkHrefs(rssXML.Channel.AtomLinks, "hub"),
+		Description: rssXML.Channel.Description,
+		PubDate:     parseTime(rssXML.Channel.PubDate),
+		Type:        "RSS",
+		FeedType:    FeedRSS,
+		ITunes:      parseITunesFeed(rssXML.Channel),
+		Categories:  parseRSSCategories(rssXML.Channel.Categories),
+		Author:      strings.TrimSpace(rssXML.Channel.ManagingEditor),
+		Language:    strings.TrimSpace(rssXML.Channel.Language),
+		Copyright:   strings.TrimSpace(rssXML.Channel.Copyright),
+		Extensions:  parseExtensions(rssXML.Channel.Extensions),
+	}
+
+	if strings.TrimSpace(feed.Link) == "" {
+		feed.Link = atomPageLink(rssXML.Channel.AtomLinks)
+	}
+	if feed.Author == "" {
+		feed.Author = rssXML.Channel.AtomAuthor.name()
+	}
+	if feed.Language == "" {
+		feed.Language = strings.TrimSpace(rssXML.Channel.DCLanguage)
+	}
+	if feed.Copyright == "" {
+		feed.Copyright = strings.TrimSpace(rssXML.Channel.DCRights)
+	}
+
+	rssXML.Channel.syndicationXML.apply(feed)
+	retryDate(feed, &feed.PubDate, rssXML.Channel.PubDate)
+	feed.LastBuildDate = parseTime(rssXML.Channel.LastBuildDate)
+	feed.TTL = time.Duration(parseCount(rssXML.Channel.TTL)) * time.Minute
+	retryDate(feed, &feed.LastBuildDate, rssXML.Channel.LastBuildDate)
+
+	if name := strings.TrimSpace(rssXML.Channel.Generator); name != "" {
+		feed.Generator = &Generator{Name: name}
+	}
+
+	feed.Image = rssXML.Channel.Image.toImage()
+
+	return feed
+}
+
+// rssItem builds an item from an RSS <item>. We record any warnings on feed.
+func rssItem(feed *Feed, item rssItemXML) Item {
+	pubDate, pubDateRaw, warning := rssItemDate(item)
+	if warning != "" {
+		feed.Warnings = append(feed.Warnings, warning)
+	}
+
+	feedItem := Item{
+		Title:       plaintext(item.Title),
+		Link:        item.Link,
+		Description: item.Description,
+		PubDate:     pubDate,
+		GUID:        item.GUID.Value,
+		Content:     item.ContentEncoded,
+		Summary:     item.AtomSummary.String(),
+		Categories:  parseRSSCategories(item.Categories),
+		Enclosures:  parseRSSEnclosures(item.Enclosures),
+		Author:      rssItemAuthor(item),
+		Copyright:   strings.TrimSpace(item.DCRights),
+		PubDateRaw:  pubDateRaw,
+		Extensions:  parseExtensions(item.Extensions),
+	}
+	if strings.TrimSpace(feedItem.Link) == "" {
+		feedItem.Link = atomPageLink(item.AtomLinks)
+	}
+	if strings.TrimSpace(feedItem.Description) == "" {
+		feedItem.Description = feedItem.Summary
+	}
+	feedItem.GUIDIsPermaLink = item.GUID.isPermaLink()
+	feedItem.Rating, feedItem.AdultContent = parseRating(item.MediaRatings,
+		item.ITunesExplicit)
+	feedItem.Media = parseMediaContents(item.MediaContents)
+	for _, group := range item.MediaGroups {
+		if contents := parseMediaContents(group.Contents); contents != nil {
+			feedItem.MediaGroups = append(feedItem.MediaGroups, contents)
+		}
+	}
+	feedItem.Podcast = parsePodcastItem(item)
+	feedItem.ITunes = parseITunesItem(item)
+	feedItem.ImageURL = item.ITunesImage.url()
+	if feedItem.ImageURL == "" && feed.ITunes != nil {
+		feedItem.ImageURL = feed.ITunes.ImageURL
+	}
+	item.commentsXML.apply(&feedItem)
+	reportItemErrors(item.Raw, feedItem)
+	return feedItem
+}
+
+// finishFeed applies processing common to every format once we've built the
+// feed.
+func finishFeed(feed *Feed) {
+	if feed.TTL == 0 {
+		feed.TTL = feed.SuggestedInterval()
+	}
+
+	if config.SkipEmptyItems {
+		skipEmptyItems(feed)
+	}
+
+	if config.NormalizePercentEncoding {
+		feed.ID = normalizePercentEncoding(feed.ID)
+		feed.Link = normalizePercentEncoding(feed.Link)
+		feed.Self = normalizePercentEncoding(feed.Self)
+	}
+
+	if config.SanitizeHTML {
+		feed.Description = sanitizeHTML(feed.Description)
+	}
+
+	for i := range feed.Items {
+		finishItem(feed, &feed.Items[i])
+	}
+}
+
+// finishItem applies processing common to every format to one of the feed's
+// items. We record any warnings on feed.
+func finishItem(feed *Feed, item *Item) {
+	retryDate(feed, &item.PubDate, item.PubDateRaw)
+	warnBadDate(feed, *item)
+
+	if config.NormalizePercentEncoding {
+		item.GUID = normalizePercentEncoding(item.GUID)
+		item.Link = normalizePercentEncoding(item.Link)
+	}
+
+	if len(config.AllowedSchemes) > 0 {
+		sanitizeSchemes(feed, item)
+	}
+
+	// Rights declared on the feed cover items that don't declare their own.
+	if item.Copyright == "" {
+		item.Copyright = feed.Copyright
+	}
+
+	if config.InheritAuthor && item.Author == "" {
+		item.Author = feed.Author
+	}
+
+	if config.SanitizeHTML {
+		item.Description = sanitizeHTML(item.Description)
+		item.Content = sanitizeHTML(item.Content)
+		item.Summary = sanitizeHTML(item.Summary)
+	}
+
+	if config.MaxContentBytes > 0 {
+		limitContent(feed, item)
+	}
+}
+
+// warnBadDate records a warning in the feed if the item has a date we
+// couldn't parse.
+func warnBadDate(feed *Feed, item Item) {
+	if !item.PubDate.IsZero() || strings.TrimSpace(item.PubDateRaw) == "" {
+		return
+	}
+	if _, err := ParseTime(item.PubDateRaw); err != nil {
+		feed.Warnings = append(feed.Warnings, fmt.Sprintf("item [%s]: %s",
+			item.Title, err))
+	}
+}
+
+// normalizePercentEncoding decodes percent-encoded unreserved characters and
+// uppercases the hex digits of any other escapes. See the
+// NormalizePercentEncoding setting.
+func normalizePercentEncoding(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteString(strings.ToUpper(s[i+1 : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') ||
+		('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// isUnreserved reports whether c is an unreserved character in a URI (RFC
+// 3986 section 2.3).
+func isUnreserved(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') ||
+		('0' <= c && c <= '9') || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// sanitizeSchemes applies the AllowedSchemes setting to the item's link and
+// enclosures. It records what it removed in the feed's warnings.
+func sanitizeSchemes(feed *Feed, item *Item) {
+	if !allowedScheme(item.Link) {
+		feed.Warnings = append(feed.Warnings, fmt.Sprintf(
+			"removed link [%s] of item [%s] as its scheme is not allowed",
+			item.Link, item.Title))
+		item.Link = ""
+	}
+
+	var enclosures []Enclosure
+	for _, enclosure := range item.Enclosures {
+		if !allowedScheme(enclosure.URL) {
+			feed.Warnings = append(feed.Warnings, fmt.Sprintf(
+				"removed enclosure [%s] of item [%s] as its scheme is not allowed",
+				enclosure.URL, item.Title))
+			continue
+		}
+		enclosures = append(enclosures, enclosure)
+	}
+	item.Enclosures = enclosures
+}
+
+// allowedScheme reports whether the URL's scheme is one the AllowedSchemes
+// setting allows. Blank and relative URLs are allowed.
+//
+// We look for the scheme ourselves rather than using url.Parse so URLs that
+// don't parse can't slip through.
+func allowedScheme(rawURL string) bool {
+	return hasSchemeIn(rawURL, config.AllowedSchemes)
+}
+
+// hasSchemeIn reports whether the URL's scheme is one of schemes (compared
+// case insensitively). Relative URLs have no scheme and we always accept
+// them.
+func hasSchemeIn(rawURL string, schemes []string) bool {
+	rawURL = strings.TrimSpace(rawURL)
+	colon := strings.Index(rawURL, ":")
+	if colon == -1 {
+		return true
+	}
+
+	// A colon after a /, ? or # is not ending a scheme, e.g. /a:b.
+	if i := strings.IndexAny(rawURL, "/?#"); i != -1 && i < colon {
+		return true
+	}
+
+	scheme := rawURL[:colon]
+	for _, allowed := range schemes {
+		if strings.EqualFold(scheme, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipEmptyItems removes items that have no title, description, or content.
+// It records how many it removed in the feed's warnings.
+func skipEmptyItems(feed *Feed) {
+	var items []Item
+	skipped := 0
+	for _, item := range feed.Items {
+		if isEmptyItem(item) {
+			skipped++
+			continue
+		}
+		items = append(items, item)
+	}
+
+	if skipped == 0 {
+		return
+	}
+
+	feed.Items = items
+	warnSkipped(feed, skipped)
+}
+
+// isEmptyItem decides whether an item has no title, description, or content.
+func isEmptyItem(item Item) bool {
+	return strings.TrimSpace(item.Title) == "" &&
+		strings.TrimSpace(item.Description) == "" &&
+		strings.TrimSpace(item.Content) == ""
+}
+
+// warnSkipped records that we skipped empty items, if we did.
+func warnSkipped(feed *Feed, skipped int) {
+	if skipped == 0 {
+		return
+	}
+	feed.Warnings = append(feed.Warnings,
+		fmt.Sprintf("skipped %d empty item(s)", skipped))
+}
+
+// reportItemErrors calls the OnItemError callback, if there is one, for each
+// problem with an item.
+func reportItemErrors(raw string, item Item) {
+	if config.OnItemError == nil {
+		return
+	}
+
+	if item.PubDate.IsZero() && strings.TrimSpace(item.PubDateRaw) != "" {
+		_, lenientOK := parseTimeLenient(item.PubDateRaw)
+		if !config.Lenient || !lenientOK {
+			config.OnItemError(raw, fmt.Errorf("unable to parse date [%s]",
+				strings.TrimSpace(item.PubDateRaw)))
+		}
+	}
+
+	if strings.TrimSpace(item.Title) == "" &&
+		strings.TrimSpace(item.Description) == "" &&
+		strings.TrimSpace(item.Content) == "" {
+		config.OnItemError(raw, errors.New("item has no title or description"))
+	}
+}
+
+// limitContent applies the MaxContentBytes setting to an item's description
+// and content. It records what it did in the feed's warnings.
+func limitContent(feed *Feed, item *Item) {
+	for _, field := range []struct {
+		name  string
+		value *string
+	}{
+		{"description", &item.Description},
+		{"content", &item.Content},
+	} {
+		size := len(*field.value)
+		if size <= config.MaxContentBytes {
+			continue
+		}
+
+		if config.DropOversizedContent {
+			*field.value = ""
+			feed.Warnings = append(feed.Warnings, fmt.Sprintf(
+				"dropped %s of item [%s] as it is %d bytes", field.name, item.Title,
+				size))
+			continue
+		}
+
+		*field.value = truncateUTF8(*field.value, config.MaxContentBytes)
+		feed.Warnings = append(feed.Warnings, fmt.Sprintf(
+			"truncated %s of item [%s] from %d bytes", field.name, item.Title,
+			size))
+	}
+}
+
+// truncateUTF8 shortens a string to at most max bytes without splitting a
+// UTF-8 encoded character.
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// parsePodcastItem collects an item's Podcasting 2.0 elements. It returns nil
+// if there are none.
+func parsePodcastItem(item rssItemXML) *PodcastItem {
+	if len(item.PodcastTranscripts) == 0 && item.PodcastChapters == nil {
+		return nil
+	}
+
+	podcast := &PodcastItem{}
+	if len(item.PodcastTranscripts) > 0 {
+		podcast.TranscriptURL = strings.TrimSpace(item.PodcastTranscripts[0].URL)
+		podcast.TranscriptType = item.PodcastTranscripts[0].Type
+	}
+	if item.PodcastChapters != nil {
+		podcast.ChaptersURL = strings.TrimSpace(item.PodcastChapters.URL)
+		podcast.ChaptersType = item.PodcastChapters.Type
+	}
+	return podcast
+}
+
+// parseRating decides an item's rating from its <media:rating> elements and
+// <itunes:explicit>. It returns the value of the first rating, and whether
+// the item is for adults.
+func parseRating(ratings []mediaRatingXML, explicit string) (string, bool) {
+	rating := ""
+	adult := false
+
+	for i, r := range ratings {
+		value := strings.ToLower(strings.TrimSpace(r.Value))
+		if i == 0 {
+			rating = value
+		}
+
+		// urn:simple is the default scheme.
+		scheme := strings.ToLower(strings.TrimSpace(r.Scheme))
+		if (scheme == "" || scheme == "urn:simple") && value == "adult" {
+			adult = true
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(explicit)) {
+	case "true", "yes", "explicit":
+		adult = true
+	}
+
+	return rating, adult
+}
+
+// apply sets the syndication fields of the feed.
+func (s syndicationXML) apply(feed *Feed) {
+	feed.UpdatePeriod = strings.ToLower(strings.TrimSpace(s.UpdatePeriod))
+	feed.UpdateFrequency = parseCount(s.UpdateFrequency)
+}
+
+// parseRSSCategories converts <category> elements. It skips empty ones.
+func parseRSSCategories(elements []rssCategoryXML) []Category {
+	var categories []Category
+	for _, element := range elements {
+		name := strings.TrimSpace(element.Name)
+		if name == "" {
+			continue
+		}
+		categories = append(categories, Category{
+			Name:   name,
+			Domain: strings.TrimSpace(element.Domain),
+		})
+	}
+	return categories
+}
+
+// parseAtomCategories converts Atom <category> elements. The term is the
+// name and the scheme is the domain. It skips ones without a term.
+func parseAtomCategories(elements []atomCategoryXML) []Category {
+	var categories []Category
+	for _, element := range elements {
+		term := strings.TrimSpace(element.Term)
+		if term == "" {
+			continue
+		}
+		categories = append(categories, Category{
+			Name:   term,
+			Domain: strings.TrimSpace(element.Scheme),
+		})
+	}
+	return categories
+}
+
+// parseSubjects converts <dc:subject> elements to categories. It skips empty
+// ones.
+func parseSubjects(subjects []string) []Category {
+	var categories []Category
+	for _, subject := range subjects {
+		if name := strings.TrimSpace(subject); name != "" {
+			categories = append(categories, Category{Name: name})
+		}
+	}
+	return categories
+}
+
+// rssItemAuthor decides an RSS item's author. We prefer <author> and fall
+// back to <dc:creator> and then <atom:author>.
+func rssItemAuthor(item rssItemXML) string {
+	if author := strings.TrimSpace(item.Author); author != "" {
+		return author
+	}
+	if creator := strings.TrimSpace(item.DCCreator); creator != "" {
+		return creator
+	}
+	return item.AtomAuthor.name()
+}
+
+// parseRSSEnclosures converts <enclosure> elements to Enclosures. We skip any
+// without a URL.
+func parseRSSEnclosures(elements []rssEnclosureXML) []Enclosure {
+	var enclosures []Enclosure
+	for _, element := range elements {
+		url := strings.TrimSpace(element.URL)
+		if url == "" {
+			continue
+		}
+		enclosures = append(enclosures, Enclosure{
+			URL:    url,
+			Length: parseLength(element.Length),
+			Type:   strings.TrimSpace(element.Type),
+		})
+	}
+	return enclosures
+}
+
+// parseMediaContents converts <media:content> elements to MediaContents. We
+// skip any without a URL.
+func parseMediaContents(elements []mediaContentXML) []MediaContent {
+	var contents []MediaContent
+	for _, element := range elements {
+		url := strings.TrimSpace(element.URL)
+		if url == "" {
+			continue
+		}
+		isDefault, _ := strconv.ParseBool(strings.TrimSpace(element.IsDefault))
+		contents = append(contents, MediaContent{
+			URL:       url,
+			Type:      strings.TrimSpace(element.Type),
+			Medium:    strings.TrimSpace(element.Medium),
+			FileSize:  parseLength(element.FileSize),
+			Bitrate:   parseCount(element.Bitrate),
+			Width:     parseCount(element.Width),
+			Height:    parseCount(element.Height),
+			Duration:  time.Duration(parseCount(element.Duration)) * time.Second,
+			IsDefault: isDefault,
+		})
+	}
+	return contents
+}
+
+// atomEnclosures converts an entry's <link rel="enclosure"> elements to
+// Enclosures.
+func atomEnclosures(links []atomLink) []Enclosure {
+	var enclosures []Enclosure
+	for _, l := range links {
+		href := strings.TrimSpace(l.Href)
+		if l.Rel != "enclosure" || href == "" {
+			continue
+		}
+		enclosures = append(enclosures, Enclosure{
+			URL:    href,
+			Length: parseLength(l.Length),
+			Type:   strings.TrimSpace(l.Type),
+		})
+	}
+	return enclosures
 }
 
-// atomLink describes a <link> element.
-type atomLink struct {
-	Href string `xml:"href,attr"`
-	Rel  string `xml:"rel,attr"`
+// parseLength parses a size in bytes. It returns 0 if the string is not a
+// valid size.
+func parseLength(s string) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
 }
 
-// atomItemXML describes an item/entry in the feed. Atom calls these entries,
-// but for consistency with other formats I support, I call them items.
-type atomItemXML struct {
-	// Human readable title. Must be present.
-	Title string `xml:"title"`
+// dateConflictThreshold is how far apart an item's dates may be before we
+// consider them to disagree.
+const dateConflictThreshold = time.Hour
 
-	// Web resource. Zero or more.
-	Links []atomLink `xml:"link"`
+// rssItemDate decides which of an RSS item's dates to use. We prefer <pubDate>
+// and fall back to <dc:date> if there is no <pubDate> or it doesn't parse.
+//
+// It returns the parsed date and the raw text we parsed it from. If the item
+// has both dates and they disagree by more than dateConflictThreshold, it also
+// returns a warning describing that.
+func rssItemDate(item rssItemXML) (time.Time, string, string) {
+	if strings.TrimSpace(item.PubDate) == "" {
+		return parseTime(item.DCDate), item.DCDate, ""
+	}
 
-	// Last time entry updated. Must be present.
-	Updated string `xml:"updated"`
+	pubDate := parseTime(item.PubDate)
+	if strings.TrimSpace(item.DCDate) == "" {
+		return pubDate, item.PubDate, ""
+	}
 
-	// Content is optional.
-	Content string `xml:"content"`
+	dcDate := parseTime(item.DCDate)
+	if pubDate.IsZero() && !dcDate.IsZero() {
+		return dcDate, item.DCDate, ""
+	}
+	if pubDate.IsZero() || dcDate.IsZero() {
+		return pubDate, item.PubDate, ""
+	}
 
-	// ID is required. Unique identifier.
-	ID string `xml:"id"`
+	diff := pubDate.Sub(dcDate)
+	if dcDate.After(pubDate) {
+		diff = dcDate.Sub(pubDate)
+	}
+	if diff <= dateConflictThreshold {
+		return pubDate, item.PubDate, ""
+	}
+
+	return pubDate, item.PubDate, fmt.Sprintf(
+		"item [%s] has pubDate [%s] and dc:date [%s] which differ by %s, using pubDate",
+		item.Title, strings.TrimSpace(item.PubDate),
+		strings.TrimSpace(item.DCDate), diff)
 }
 
-// ParseFeedXML takes a feed's raw XML and returns a struct describing the feed.
-//
-// We support various formats: RSS, RDF, Atom. We try our best to decode the
-// feed in one of them.
-func ParseFeedXML(data []byte) (*Feed, error) {
-	// Hack. Strip invalid UTF-8 before trying to decode. We don't do this in all
-	// cases as we might not have UTF-8 yet.
-	d := newDecoder(data)
-	token, err := d.Token()
-	if err != nil {
-		return nil, errors.Wrap(err, "error decoding token")
+// parseITunesFeed pulls the iTunes namespace elements out of an RSS channel.
+// It returns nil if there are none.
+func parseITunesFeed(channel rssChannelXML) *ITunesFeed {
+	itunes := &ITunesFeed{
+		Type: strings.TrimSpace(channel.ITunesType),
 	}
-	if procInst, ok := token.(xml.ProcInst); ok {
-		inst := bytes.ToLower(procInst.Inst)
-		if bytes.Contains(inst, []byte("utf-8")) {
-			data = bytes.ToValidUTF8(data, []byte("\uFFFD"))
+	if channel.ITunesOwner != nil {
+		itunes.OwnerName = strings.TrimSpace(channel.ITunesOwner.Name)
+		itunes.OwnerEmail = strings.TrimSpace(channel.ITunesOwner.Email)
+	}
+	itunes.ImageURL = channel.ITunesImage.url()
+
+	for _, category := range channel.ITunesCategories {
+		c := ITunesCategory{Name: strings.TrimSpace(category.Text)}
+		for _, sub := range category.Subcategories {
+			if name := strings.TrimSpace(sub.Text); name != "" {
+				c.Subcategories = append(c.Subcategories, name)
+			}
+		}
+		if c.Name != "" {
+			itunes.Categories = append(itunes.Categories, c)
 		}
 	}
 
-	channelRSS, errRSS := parseAsRSS(data)
-	if errRSS == nil {
-		return channelRSS, nil
+	if itunes.OwnerName == "" && itunes.OwnerEmail == "" && itunes.Type == "" &&
+		itunes.ImageURL == "" && len(itunes.Categories) == 0 {
+		return nil
 	}
+	return itunes
+}
 
-	channelRDF, errRDF := parseAsRDF(data)
-	if errRDF == nil {
-		return channelRDF, nil
+// parseITunesItem pulls the iTunes namespace elements out of an RSS item. It
+// returns nil if there are none.
+func parseITunesItem(item rssItemXML) *ITunesItem {
+	if item.ITunesAuthor == "" && item.ITunesDuration == "" &&
+		item.ITunesEpisode == "" && item.ITunesSeason == "" &&
+		item.ITunesExplicit == "" {
+		return nil
 	}
 
-	channelAtom, errAtom := parseAsAtom(data)
-	if errAtom == nil {
-		return channelAtom, nil
+	explicit := strings.ToLower(strings.TrimSpace(item.ITunesExplicit))
+
+	return &ITunesItem{
+		Author:   strings.TrimSpace(item.ITunesAuthor),
+		Duration: parseITunesDuration(item.ITunesDuration),
+		Episode:  parseCount(item.ITunesEpisode),
+		Season:   parseCount(item.ITunesSeason),
+		Explicit: explicit == "true" || explicit == "yes" || explicit == "explicit",
+	}
+}
+
+// parseITunesDuration parses an <itunes:duration>. This may be a number of
+// seconds, or HH:MM:SS or MM:SS. It returns zero if it can't parse it.
+func parseITunesDuration(s string) time.Duration {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 {
+		return 0
+	}
+
+	var total time.Duration
+	for i, part := range parts {
+		// Only the seconds may have a fraction.
+		if i == len(parts)-1 {
+			seconds, err := strconv.ParseFloat(part, 64)
+			if err != nil || seconds < 0 {
+				return 0
+			}
+			return total*time.Second + time.Duration(seconds*float64(time.Second))
+		}
+
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0
+		}
+		total = (total + time.Duration(n)) * 60
 	}
 
-	return nil, fmt.Errorf("unable to parse as RSS (%s), RDF (%s), or Atom (%s)",
-		errRSS, errRDF, errAtom)
+	return 0
 }
 
-// parseAsRSS attempts to parse the buffer as if it contains an RSS feed.
-func parseAsRSS(data []byte) (*Feed, error) {
-	rssXML := rssXML{}
-	if err := newDecoder(data).Decode(&rssXML); err != nil {
-		return nil, fmt.Errorf("RSS XML decode error: %v", err)
+// apply sets the comment related fields of the item.
+func (c commentsXML) apply(item *Item) {
+	if c.SlashSection != "" || c.SlashDepartment != "" ||
+		c.SlashComments != "" || c.SlashHitParade != "" {
+		item.Slash = &Slash{
+			Section:    strings.TrimSpace(c.SlashSection),
+			Department: strings.TrimSpace(c.SlashDepartment),
+			Comments:   parseCount(c.SlashComments),
+			HitParade:  strings.TrimSpace(c.SlashHitParade),
+		}
 	}
 
-	if strings.ToLower(rssXML.XMLName.Local) != "rss" {
-		return nil, errors.New("base tag is not RSS")
+	if c.ThreadTotal != "" {
+		item.Thread = &Thread{Total: parseCount(c.ThreadTotal)}
 	}
 
-	// Build a channel struct now. It's common to the base formats we support.
+	item.CommentsFeedURL = strings.TrimSpace(c.WFWCommentRSS)
 
-	feed := &Feed{
-		Title:       rssXML.Channel.Title,
-		Link:        rssXML.Channel.Link,
-		Description: rssXML.Channel.Description,
-		PubDate:     parseTime(rssXML.Channel.PubDate),
-		Type:        "RSS",
+	if c.SlashComments != "" {
+		item.CommentCount = item.Slash.Comments
+		return
 	}
+	if item.Thread != nil {
+		item.CommentCount = item.Thread.Total
+	}
+}
 
-	if config.Verbose {
-		log.Printf("Parsed channel as RSS [%s]", feed.Title)
+// parseCount parses a non-negative count. It returns 0 if the string is not a
+// valid count.
+func parseCount(s string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 {
+		return 0
 	}
+	return n
+}
 
-	for _, item := range rssXML.Channel.Items {
-		feed.Items = append(feed.Items,
-			Item{
-				Title:       item.Title,
-				Link:        item.Link,
-				Description: item.Description,
-				PubDate:     parseTime(item.PubDate),
-				GUID:        item.GUID,
-			})
+// parseExtensions converts elements we didn't otherwise parse into
+// Extensions, keeping them in document order. It returns nil unless the
+// CaptureExtensions setting is on.
+func parseExtensions(elements []extensionXML) Extensions {
+	if !config.CaptureExtensions || len(elements) == 0 {
+		return nil
 	}
 
-	return feed, nil
+	var exts Extensions
+	for _, element := range elements {
+		space := element.XMLName.Space
+		// newDecoder() puts elements with no namespace into a placeholder one.
+		if space == "default" {
+			space = ""
+		}
+
+		var attrs []xml.Attr
+		for _, attr := range element.Attrs {
+			if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+				continue
+			}
+			attrs = append(attrs, attr)
+		}
+
+		exts = append(exts, Extension{
+			Space: space,
+			Name:  element.XMLName.Local,
+			Attrs: attrs,
+			Value: element.Value,
+		})
+	}
+	return exts
 }
 
-func newDecoder(data []byte) *xml.Decoder {
-	d := xml.NewDecoder(bytes.NewBuffer(data))
+// newDecoder makes a decoder for a feed. If ctx can be done, decoding fails
+// once it is. See contextReader.
+func newDecoder(ctx context.Context, data []byte) *xml.Decoder {
+	var r io.Reader = bytes.NewBuffer(data)
+	if ctx.Done() != nil {
+		r = &contextReader{ctx: ctx, r: bytes.NewReader(data)}
+	}
+
+	d := xml.NewDecoder(r)
 	d.CharsetReader = charset.NewReaderLabel
 	d.DefaultSpace = "default"
 	return d
 }
 
+// contextCheckBytes is how many bytes contextReader reads between checking its
+// context.
+const contextCheckBytes = 4096
+
+// contextReader reads from a bytes.Reader until its context is done. After
+// that, reads fail with the context's error.
+//
+// The XML decoder reads a byte at a time if it can, so we implement
+// io.ByteReader as well. Checking the context is not free, so we only do it
+// every contextCheckBytes bytes.
+type contextReader struct {
+	ctx   context.Context
+	r     *bytes.Reader
+	count int
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+func (c *contextReader) ReadByte() (byte, error) {
+	c.count++
+	if c.count%contextCheckBytes == 0 {
+		if err := c.ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+	return c.r.ReadByte()
+}
+
 // parseAsRDF attempts to parse the buffer as if it contains an RDF feed.
 //
 // See parseAsRSS() for a similar function, but for RSS.
-func parseAsRDF(data []byte) (*Feed, error) {
+func parseAsRDF(ctx context.Context, data []byte) (*Feed, error) {
 	rdfXML := rdfXML{}
-	if err := newDecoder(data).Decode(&rdfXML); err != nil {
+	if err := newDecoder(ctx, data).Decode(&rdfXML); err != nil {
 		return nil, fmt.Errorf("RDF XML decode error: %v", err)
 	}
 
@@ -214,6 +1709,24 @@ func parseAsRDF(data []byte) (*Feed, error) {
 		return nil, errors.New("base tag is not RDF")
 	}
 
+	feed := newRDFFeed(&rdfXML)
+
+	if config.Verbose {
+		log.Printf("Parsed channel as RDF [%s]", feed.Title)
+	}
+
+	for _, item := range rdfXML.RDFItems {
+		feed.Items = append(feed.Items, rdfItem(item))
+	}
+
+	finishFeed(feed)
+
+	return feed, nil
+}
+
+// newRDFFeed builds a feed from an RDF document's metadata. It doesn't look at
+// the document's items.
+func newRDFFeed(rdfXML *rdfXML) *Feed {
 	link := ""
 	if len(rdfXML.Channel.Links) > 0 {
 		link = rdfXML.Channel.Links[0]
@@ -222,87 +1735,436 @@ func parseAsRDF(data []byte) (*Feed, error) {
 	feed := &Feed{
 		Title:       rdfXML.Channel.Title,
 		Link:        link,
+		Self:        atomLinkHref(rdfXML.Channel.AtomLinks, "self"),
+		NextPageURL: atomLinkHref(rdfXML.Channel.AtomLinks, "next"),
+		Hubs:        atomLinkHrefs(rdfXML.Channel.AtomLinks, "hub"),
 		Description: rdfXML.Channel.Description,
 		PubDate:     parseTime(rdfXML.Channel.PubDate),
 		Type:        "RDF",
+		FeedType:    FeedRDF,
+		Language:    strings.TrimSpace(rdfXML.Channel.DCLanguage),
+		Copyright:   strings.TrimSpace(rdfXML.Channel.DCRights),
+		Categories:  parseSubjects(rdfXML.Channel.DCSubjects),
+		Extensions:  parseExtensions(rdfXML.Channel.Extensions),
 	}
 
-	if config.Verbose {
-		log.Printf("Parsed channel as RDF [%s]", feed.Title)
+	rdfXML.Channel.syndicationXML.apply(feed)
+	retryDate(feed, &feed.PubDate, rdfXML.Channel.PubDate)
+
+	feed.Image = rdfXML.Image.toImage()
+	if resource := strings.TrimSpace(rdfXML.Channel.Image.Resource); resource != "" {
+		if feed.Image == nil {
+			feed.Image = &Image{}
+		}
+		if feed.Image.URL == "" {
+			feed.Image.URL = resource
+		}
 	}
 
-	for _, item := range rdfXML.RDFItems {
-		feed.Items = append(feed.Items,
-			Item{
-				Title:       item.Title,
-				Link:        item.Link,
-				Description: item.Description,
-				PubDate:     parseTime(item.PubDate),
-			})
+	return feed
+}
+
+// rdfItem builds an item from an RDF <item>.
+func rdfItem(item rdfItemXML) Item {
+	// Prefer <dc:date> as that is what RDF feeds usually use.
+	date := item.DCDate
+	if strings.TrimSpace(date) == "" {
+		date = item.PubDate
 	}
 
-	return feed, nil
+	feedItem := Item{
+		Title:       plaintext(item.Title),
+		Link:        rdfItemLink(item.Links),
+		Description: item.Description,
+		PubDate:     parseTime(date),
+		GUID:        strings.TrimSpace(item.DCIdentifier),
+		Content:     item.ContentEncoded,
+		Author:      strings.TrimSpace(item.DCCreator),
+		Copyright:   strings.TrimSpace(item.DCRights),
+		Categories:  parseSubjects(item.DCSubjects),
+		PubDateRaw:  date,
+		Extensions:  parseExtensions(item.Extensions),
+	}
+	item.commentsXML.apply(&feedItem)
+	reportItemErrors(item.Raw, feedItem)
+	return feedItem
 }
 
 // parseAsAtom attempts to parse the buffer as Atom.
 //
 // See parseAsRSS() and parseAsRDF() for similar parsing. Also I omit comments
 // that would be repeated here if they are in those functions.
-func parseAsAtom(data []byte) (*Feed, error) {
+func parseAsAtom(ctx context.Context, data []byte) (*Feed, error) {
 	atomXML := atomXML{}
-	if err := newDecoder(data).Decode(&atomXML); err != nil {
+	if err := newDecoder(ctx, data).Decode(&atomXML); err != nil {
 		return nil, fmt.Errorf("Atom XML decode error: %v", err)
 	}
 
-	// May have multiple <link> elements. Look for rel=self.
-	link := ""
-	for _, l := range atomXML.Links {
-		if l.Rel != "self" {
-			continue
-		}
-		link = l.Href
-		break
-	}
-
-	feed := &Feed{
-		Title:   atomXML.Title,
-		Link:    link,
-		PubDate: parseTime(atomXML.Updated),
-		Type:    "Atom",
-	}
+	feed := newAtomFeed(&atomXML)
 
 	if config.Verbose {
 		log.Printf("Parsed channel as Atom [%s]", feed.Title)
 	}
 
 	for _, item := range atomXML.Items {
-		link := ""
-		// Take the first. Probably we can be more intelligent.
-		if len(item.Links) > 0 {
-			link = item.Links[0].Href
-		}
-
-		feed.Items = append(feed.Items, Item{
-			Title:       item.Title,
-			Link:        link,
-			Description: item.Content,
-			PubDate:     parseTime(item.Updated),
-			GUID:        item.ID,
-		})
+		feed.Items = append(feed.Items, atomItem(atomXML.Base, item))
 	}
 
+	finishFeed(feed)
+
 	return feed, nil
 }
 
-func parseTime(pubDate string) time.Time {
-	if len(pubDate) == 0 {
-		if config.Verbose {
-			log.Print("No publication date on channel/item. Defaulting to now.")
+// newAtomFeed builds a feed from an Atom feed's metadata. It doesn't look at
+// the feed's entries.
+func newAtomFeed(atomXML *atomXML) *Feed {
+	feed := &Feed{
+		Title:       atomXML.Title.plain(),
+		Link:        atomAlternateLink(atomXML.Links),
+		Self:        atomLinkHref(atomXML.Links, "self"),
+		NextPageURL: atomLinkHref(atomXML.Links, "next"),
+		Hubs:        atomLinkHrefs(atomXML.Links, "hub"),
+		Description: atomXML.Subtitle.html(),
+		PubDate:     parseTime(atomXML.Updated),
+		Type:        "Atom",
+		FeedType:    FeedAtom,
+		ID:          atomXML.ID,
+		Author:      atomXML.Author.name(),
+		Language:    strings.TrimSpace(atomXML.Lang),
+		Copyright:   strings.TrimSpace(atomXML.Rights.String()),
+		Categories:  parseAtomCategories(atomXML.Categories),
+		Extensions:  parseExtensions(atomXML.Extensions),
+	}
+
+	atomXML.syndicationXML.apply(feed)
+	retryDate(feed, &feed.PubDate, atomXML.Updated)
+
+	if atomXML.Generator != nil {
+		feed.Generator = &Generator{
+			Name:    strings.TrimSpace(atomXML.Generator.Name),
+			URI:     atomXML.Generator.URI,
+			Version: atomXML.Generator.Version,
 		}
-		return time.Time{}
 	}
 
+	// Prefer the logo as it is the feed's artwork. The icon is often just the
+	// site's favicon.
+	if logo := strings.TrimSpace(atomXML.Logo); logo != "" {
+		feed.Image = &Image{URL: logo}
+	} else if icon := strings.TrimSpace(atomXML.Icon); icon != "" {
+		feed.Image = &Image{URL: icon}
+	}
+
+	if atomXML.Base != "" {
+		resolveFeedURLs(feed, atomXML.Base)
+	}
+
+	return feed
+}
+
+// atomItem builds an item from an Atom <entry>. base is the feed's xml:base,
+// if any.
+func atomItem(base string, item atomItemXML) Item {
+	feedItem := Item{
+		Title:       item.Title.plain(),
+		Link:        atomAlternateLink(item.Links),
+		Description: item.Content.String(),
+		Summary:     item.Summary.String(),
+		PubDate:     parseTime(item.Updated),
+		GUID:        item.ID,
+		Author:      item.Author.name(),
+		Copyright:   strings.TrimSpace(item.Rights.String()),
+		Categories:  parseAtomCategories(item.Categories),
+		Enclosures:  atomEnclosures(item.Links),
+		PubDateRaw:  item.Updated,
+		Extensions:  parseExtensions(item.Extensions),
+	}
+	feedItem.ContentType = item.Content.contentType()
+	// Many entries have only a summary.
+	if strings.TrimSpace(feedItem.Description) == "" {
+		feedItem.Description = feedItem.Summary
+		feedItem.ContentType = item.Summary.contentType()
+	}
+	if item.Source != nil {
+		feedItem.Source = &Source{
+			Title: item.Source.Title,
+			ID:    item.Source.ID,
+			Link:  atomSourceLink(item.Source.Links),
+		}
+	}
+	if base := resolveAgainst(base, item.Base); base != "" {
+		resolveItemURLs(&feedItem, base)
+	}
+	item.commentsXML.apply(&feedItem)
+	reportItemErrors(item.Raw, feedItem)
+	return feedItem
+}
+
+// itemElement returns the name of the element holding each item in a feed of
+// the given type (RSS, RDF, or Atom).
+func itemElement(feedType string) string {
+	if feedType == "Atom" {
+		return "entry"
+	}
+	return "item"
+}
+
+// itemDecoder decodes the item starting at start into an Item. We record any
+// warnings on feed.
+type itemDecoder func(feed *Feed, d *xml.Decoder, start *xml.StartElement) (
+	Item, error)
+
+// formatDecoders returns what we need to decode a feed of the given type (RSS,
+// RDF, or Atom) a piece at a time rather than all at once: doc is what to
+// decode the document into, newFeed builds a feed from the metadata in doc,
+// and decodeItem decodes one item.
+func formatDecoders(feedType string) (doc interface{}, newFeed func() *Feed,
+	decodeItem itemDecoder) {
+	switch feedType {
+	case "RSS":
+		rssXML := &rssXML{}
+		return rssXML, func() *Feed { return newRSSFeed(rssXML) },
+			func(feed *Feed, d *xml.Decoder, start *xml.StartElement) (Item, error) {
+				item := rssItemXML{}
+				if err := d.DecodeElement(&item, start); err != nil {
+					return Item{}, err
+				}
+				return rssItem(feed, item), nil
+			}
+	case "RDF":
+		rdfXML := &rdfXML{}
+		return rdfXML, func() *Feed { return newRDFFeed(rdfXML) },
+			func(feed *Feed, d *xml.Decoder, start *xml.StartElement) (Item, error) {
+				item := rdfItemXML{}
+				if err := d.DecodeElement(&item, start); err != nil {
+					return Item{}, err
+				}
+				return rdfItem(item), nil
+			}
+	}
+	atomXML := &atomXML{}
+	return atomXML, func() *Feed { return newAtomFeed(atomXML) },
+		func(feed *Feed, d *xml.Decoder, start *xml.StartElement) (Item, error) {
+			item := atomItemXML{}
+			if err := d.DecodeElement(&item, start); err != nil {
+				return Item{}, err
+			}
+			return atomItem(atomXML.Base, item), nil
+		}
+}
+
+// atomLinkHref returns the href of the first link with the given rel, or
+// blank if there is none.
+func atomLinkHref(links []atomLink, rel string) string {
+	for _, l := range links {
+		if l.Rel == rel {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// atomAlternateLink picks the link to the human readable page from a feed's
+// or entry's links. There may be several, such as rel=self or rel=edit links
+// for use by software. We prefer rel=alternate, then a link with no rel (which
+// means alternate), and otherwise take the first.
+func atomAlternateLink(links []atomLink) string {
+	if href := atomPageLink(links); href != "" {
+		return href
+	}
+	if len(links) > 0 {
+		return links[0].Href
+	}
+	return ""
+}
+
+// atomPageLink returns the href of the link to the human readable page. This
+// is the rel=alternate link, or a link with no rel. It returns blank if there
+// is neither. Unlike atomAlternateLink() it doesn't fall back to other links,
+// as we use it where the links are likely only rel=self.
+func atomPageLink(links []atomLink) string {
+	if href := atomLinkHref(links, "alternate"); href != "" {
+		return href
+	}
+	return atomLinkHref(links, "")
+}
+
+// atomLinkHrefs returns the hrefs of all links with the given rel.
+func atomLinkHrefs(links []atomLink, rel string) []string {
+	var hrefs []string
+	for _, l := range links {
+		if l.Rel == rel {
+			hrefs = append(hrefs, l.Href)
+		}
+	}
+	return hrefs
+}
+
+// atomSourceLink picks the link to use for an entry's <source>. We prefer the
+// feed's own URL (rel=self) and otherwise take the first.
+func atomSourceLink(links []atomLink) string {
+	if self := atomLinkHref(links, "self"); self != "" {
+		return self
+	}
+	if len(links) > 0 {
+		return links[0].Href
+	}
+	return ""
+}
+
+// resolveAgainst resolves ref against base. Unlike resolveURL(), if it can't
+// (base is blank, or either doesn't parse), it returns ref as it is. If ref is
+// absolute, the result is ref.
+func resolveAgainst(base, ref string) string {
+	if strings.TrimSpace(base) == "" {
+		return ref
+	}
+	if strings.TrimSpace(ref) == "" {
+		return base
+	}
+	if resolved := resolveURL(strings.TrimSpace(base), ref); resolved != "" {
+		return resolved
+	}
+	return ref
+}
+
+// resolveFeedURLs resolves the feed's own URLs against base. This doesn't
+// include its items' URLs. See resolveItemURLs().
+func resolveFeedURLs(feed *Feed, base string) {
+	feed.Link = resolveLink(base, feed.Link)
+	feed.Self = resolveLink(base, feed.Self)
+	feed.NextPageURL = resolveLink(base, feed.NextPageURL)
+	for i := range feed.Hubs {
+		feed.Hubs[i] = resolveLink(base, feed.Hubs[i])
+	}
+	if feed.Image != nil {
+		feed.Image.URL = resolveLink(base, feed.Image.URL)
+		feed.Image.Link = resolveLink(base, feed.Image.Link)
+	}
+}
+
+// resolveItemURLs resolves the item's link, enclosure URLs, and image URL
+// against base.
+func resolveItemURLs(item *Item, base string) {
+	item.Link = resolveLink(base, item.Link)
+	for i := range item.Enclosures {
+		item.Enclosures[i].URL = resolveLink(base, item.Enclosures[i].URL)
+	}
+	item.ImageURL = resolveLink(base, item.ImageURL)
+}
+
+// resolveLink is like resolveAgainst() except a blank link stays blank.
+func resolveLink(base, link string) string {
+	if strings.TrimSpace(link) == "" {
+		return link
+	}
+	return resolveAgainst(base, link)
+}
+
+// resolveRelativeURLs resolves the relative URLs in the feed and its items to
+// absolute ones. We resolve them against baseURL, or if it is blank, the
+// feed's self link. If neither is absolute we leave the URLs as they are.
+func resolveRelativeURLs(feed *Feed, baseURL string) {
+	base := feedBaseURL(feed, baseURL)
+	if base == "" {
+		return
+	}
+
+	resolveFeedURLs(feed, base)
+	for i := range feed.Items {
+		resolveItemURLs(&feed.Items[i], base)
+	}
+}
+
+// feedBaseURL decides the URL to resolve the feed's relative URLs against. See
+// resolveRelativeURLs(). It returns blank if there is no absolute one.
+func feedBaseURL(feed *Feed, baseURL string) string {
+	base := strings.TrimSpace(baseURL)
+	if base == "" {
+		base = strings.TrimSpace(feed.Self)
+	}
+	u, err := url.Parse(base)
+	if err != nil || !u.IsAbs() {
+		return ""
+	}
+	return base
+}
+
+// dateLayouts holds layouts registered with RegisterDateLayout.
+var dateLayouts struct {
+	sync.RWMutex
+	layouts []string
+}
+
+// RegisterDateLayout adds a date layout (in the format the time package uses)
+// for us to try when parsing dates. This lets you support a feed with an
+// unusual date format.
+//
+// We try registered layouts after the built in ones, in the order they were
+// registered. Dates without a timezone are taken to be UTC.
+//
+// It is safe to call from multiple goroutines, though typically you would
+// call it from an init function.
+func RegisterDateLayout(layout string) {
+	dateLayouts.Lock()
+	defer dateLayouts.Unlock()
+	dateLayouts.layouts = append(dateLayouts.layouts, layout)
+}
+
+// registeredDateLayouts returns a copy of the registered date layouts.
+func registeredDateLayouts() []string {
+	dateLayouts.RLock()
+	defer dateLayouts.RUnlock()
+	return append([]string(nil), dateLayouts.layouts...)
+}
+
+// otherDateLayouts are less common date formats we've seen in feeds. We try
+// them in order after the standard ones. Dates without a timezone are taken to
+// be UTC.
+var otherDateLayouts = []string{
+	// RFC 822 allows a single digit day: Mon, 2 Jan 2006 15:04:05 -0700
+	"Mon, _2 Jan 2006 15:04:05 -0700",
+	"Mon, _2 Jan 2006 15:04:05 MST",
+
+	// The day of the week is optional in RFC 822.
+	"_2 Jan 2006 15:04:05 -0700",
+	"_2 Jan 2006 15:04:05 MST",
+
+	// RFC 822 with two digit years.
+	time.RFC822,
+	time.RFC822Z,
+	"Mon, 02 Jan 06 15:04:05 MST",
+	"Mon, 02 Jan 06 15:04:05 -0700",
+
+	// RFC 850: Monday, 02-Jan-06 15:04:05 MST
+	time.RFC850,
+
+	// ISO 8601 without seconds: 2006-01-02T15:04Z or 2006-01-02T15:04+07:00
+	"2006-01-02T15:04Z07:00",
+
+	// ISO 8601 with a space rather than a T, with and without a zone.
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+
+	// ISO 8601 without a zone.
+	"2006-01-02T15:04:05",
+
+	// Only a date.
+	"2006-01-02",
+}
+
+// ParseTime parses a date in any of the formats we know of from feeds. These
+// include RFC 1123, RFC 3339, and many common variants of them, as well as
+// any layouts registered with RegisterDateLayout. Dates without a timezone are
+// taken to be UTC. The time we return is in UTC.
+//
+// It returns an error if the date is blank or in no format we know.
+func ParseTime(pubDate string) (time.Time, error) {
 	pubDate = strings.TrimSpace(pubDate)
+	if pubDate == "" {
+		return time.Time{}, errors.New("date is blank")
+	}
 
 	// Use RFC1123 time format for parsing. This appears to be what is present in
 	// the Slashdot feed, though I expect this could vary in other feed
@@ -312,7 +2174,7 @@ func parseTime(pubDate string) time.Time {
 	pubDateTimeParsed, err := time.ParseInLocation(time.RFC1123, pubDate, time.UTC)
 	// We use the parsed time only if we had no errors parsing it.
 	if err == nil {
-		return pubDateTimeParsed.In(time.UTC)
+		return pubDateTimeParsed.In(time.UTC), nil
 	}
 
 	// Try another format.
@@ -325,23 +2187,110 @@ func parseTime(pubDate string) time.Time {
 	pubDateTimeParsed, err = time.ParseInLocation(time.RFC1123Z, pubDate, time.UTC)
 	// We use the parsed time only if we had no errors parsing it.
 	if err == nil {
-		return pubDateTimeParsed.In(time.UTC)
+		return pubDateTimeParsed.In(time.UTC), nil
 	}
 
 	// Slashdot RDF format: 2015-03-03T21:29:00+00:00
+	//
+	// This also accepts fractional seconds, which Atom feeds may have:
+	// 2024-01-02T03:04:05.123Z
 	pubDateTimeParsed, err = time.ParseInLocation(time.RFC3339, pubDate, time.UTC)
 	if err == nil {
-		return pubDateTimeParsed.In(time.UTC)
+		return pubDateTimeParsed.In(time.UTC), nil
 	}
 
 	// yarchive.net: Sun, 09 Apr 2017 05:06 GMT
 	yarchive := "Mon, _2 Jan 2006 15:04 MST"
 	pubDateTimeParsed, err = time.ParseInLocation(yarchive, pubDate, time.UTC)
 	if err == nil {
-		return pubDateTimeParsed.In(time.UTC)
+		return pubDateTimeParsed.In(time.UTC), nil
+	}
+
+	for _, layout := range otherDateLayouts {
+		pubDateTimeParsed, err = time.ParseInLocation(layout, pubDate, time.UTC)
+		if err == nil {
+			return pubDateTimeParsed.In(time.UTC), nil
+		}
+	}
+
+	for _, layout := range registeredDateLayouts() {
+		pubDateTimeParsed, err = time.ParseInLocation(layout, pubDate, time.UTC)
+		if err == nil {
+			return pubDateTimeParsed.In(time.UTC), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("no format worked for date [%s]", pubDate)
+}
+
+// parseTime is like ParseTime except it returns the zero time if the date
+// doesn't parse.
+func parseTime(pubDate string) time.Time {
+	if len(pubDate) == 0 {
+		if config.Verbose {
+			log.Print("No publication date on channel/item. Defaulting to now.")
+		}
+		return time.Time{}
+	}
+
+	t, err := ParseTime(pubDate)
+	if err != nil {
+		log.Printf("No format worked for date [%s].", strings.TrimSpace(pubDate))
+		return time.Time{}
 	}
+	return t
+}
+
+// retryDate tries to parse a date we couldn't parse normally if the Lenient
+// setting is on. If it works, it sets t and records a warning in the feed.
+func retryDate(feed *Feed, t *time.Time, raw string) {
+	if !config.Lenient || !t.IsZero() || strings.TrimSpace(raw) == "" {
+		return
+	}
+
+	parsed, ok := parseTimeLenient(raw)
+	if !ok {
+		return
+	}
+
+	*t = parsed
+	feed.Warnings = append(feed.Warnings, fmt.Sprintf(
+		"parsed date [%s] by ignoring its timezone", strings.TrimSpace(raw)))
+}
+
+// zonelessLayouts are date formats without a timezone. We use them once we
+// drop a bogus timezone from a date.
+var zonelessLayouts = []string{
+	"Mon, _2 Jan 2006 15:04:05",
+	"Mon, _2 Jan 2006 15:04",
+	"_2 Jan 2006 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// parseTimeLenient parses a date that has a trailing textual timezone we
+// can't use, such as "Sun, 30 Jun 2013 21:26:26 +0000 UTC" or "Sun, 30 Jun
+// 2013 21:26:26 GMT+00:00".
+//
+// We drop the last word if it has a letter in it. If what remains has a
+// numeric offset we use it, otherwise we take the date to be UTC.
+func parseTimeLenient(raw string) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	i := strings.LastIndexAny(raw, " \t")
+	if i == -1 || strings.IndexFunc(raw[i+1:], unicode.IsLetter) == -1 {
+		return time.Time{}, false
+	}
+	date := strings.TrimSpace(raw[:i])
 
-	log.Printf("No format worked for date [%s].", pubDate)
+	if t, err := time.Parse(time.RFC1123Z, date); err == nil {
+		return t.In(time.UTC), true
+	}
+
+	for _, layout := range zonelessLayouts {
+		if t, err := time.ParseInLocation(layout, date, time.UTC); err == nil {
+			return t, true
+		}
+	}
 
-	return time.Time{}
+	return time.Time{}, false
 }
@@ -0,0 +1,133 @@
+package rss
+
+import (
+	"html/template"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// digestTemplate is the markup EmailDigest writes. Email clients mostly ignore
+// <style> and external stylesheets, so we lay it out with tables and style
+// each element inline. html/template escapes the values, and replaces unsafe
+// URLs (such as javascript: ones).
+var digestTemplate = template.Must(template.New("digest").Parse(
+	`<table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0" style="font-family:Arial,Helvetica,sans-serif;color:#222222;">
+  <tr>
+    <td style="padding:0 0 16px 0;font-size:22px;font-weight:bold;">
+      {{- if .Link}}<a href="{{.Link}}" style="color:#222222;text-decoration:none;">{{.Title}}</a>
+      {{- else}}{{.Title}}{{end -}}
+    </td>
+  </tr>
+{{- range .Items}}
+  <tr>
+    <td style="padding:16px 0;border-top:1px solid #dddddd;">
+      {{- if .Image}}
+      <img src="{{.Image}}" alt="" style="display:block;max-width:100%;height:auto;border:0;margin:0 0 8px 0;">
+      {{- end}}
+      <div style="font-size:18px;font-weight:bold;">
+        {{- if .Link}}<a href="{{.Link}}" style="color:#1a0dab;text-decoration:none;">{{.Title}}</a>
+        {{- else}}{{.Title}}{{end -}}
+      </div>
+      {{- if .Date}}
+      <div style="padding:4px 0 0 0;font-size:12px;color:#666666;">{{.Date}}</div>
+      {{- end}}
+      {{- if .Excerpt}}
+      <p style="margin:8px 0 0 0;font-size:14px;line-height:20px;">{{.Excerpt}}</p>
+      {{- end}}
+    </td>
+  </tr>
+{{- end}}
+</table>
+`))
+
+// digestExcerptBytes is how long item excerpts in a digest may be.
+const digestExcerptBytes = 300
+
+// DigestOptions controls what EmailDigest includes.
+type DigestOptions struct {
+	// MaxItems is how many items to include. We take the newest. If it is not
+	// positive we include every item.
+	MaxItems int
+
+	// IncludeImages controls whether we show each item's image. This is its
+	// ImageURL, or if it has none, its first image enclosure.
+	IncludeImages bool
+}
+
+// digestItem is what digestTemplate needs to know about an item.
+type digestItem struct {
+	Title   string
+	Link    string
+	Date    string
+	Excerpt string
+	Image   string
+}
+
+// EmailDigest renders the feed's latest items as HTML suitable for the body of
+// an email, such as a newsletter.
+//
+// The HTML is a table with a row per item, newest first. Each shows the
+// item's title linking to the item, its date if it has one, and an excerpt of
+// its description as plain text. See DigestOptions for what else you can
+// control.
+//
+// Everything is escaped. We convert descriptions to plain text rather than
+// trying to sanitize their HTML.
+func (f *Feed) EmailDigest(opts DigestOptions) (string, error) {
+	items := make([]Item, len(f.Items))
+	copy(items, f.Items)
+	sortItemsNewestFirst(items)
+	if opts.MaxItems > 0 && len(items) > opts.MaxItems {
+		items = items[:opts.MaxItems]
+	}
+
+	data := struct {
+		Title string
+		Link  string
+		Items []digestItem
+	}{
+		Title: f.Title,
+		Link:  f.Link,
+	}
+
+	for _, item := range items {
+		d := digestItem{
+			Title:   item.Title,
+			Link:    item.Link,
+			Excerpt: excerpt(item.Description, digestExcerptBytes),
+		}
+		if d.Title == "" {
+			d.Title = item.Link
+		}
+		if !item.PubDate.IsZero() {
+			d.Date = item.PubDate.Format("January 2, 2006")
+		}
+		if opts.IncludeImages {
+			d.Image = itemImage(item)
+		}
+		data.Items = append(data.Items, d)
+	}
+
+	var b strings.Builder
+	if err := digestTemplate.Execute(&b, data); err != nil {
+		return "", errors.Wrap(err, "error writing digest")
+	}
+
+	return b.String(), nil
+}
+
+// itemImage returns the URL of an image for the item. This is its ImageURL,
+// or if it has none, the URL of its first image enclosure. It returns blank
+// if there is neither.
+func itemImage(item Item) string {
+	if item.ImageURL != "" {
+		return item.ImageURL
+	}
+	for _, enclosure := range item.Enclosures {
+		if strings.HasPrefix(enclosure.Type, "image/") {
+			return enclosure.URL
+		}
+	}
+	return ""
+}
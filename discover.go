@@ -0,0 +1,114 @@
+package rss
+
+import (
+	"bytes"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// feedMediaTypes are the link types DiscoverFeeds() takes to be feeds.
+var feedMediaTypes = []string{
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/feed+json",
+}
+
+// DiscoverFeeds finds the feeds an HTML page advertises. This lets someone
+// give you a site's URL rather than its feed's.
+//
+// Pages advertise feeds with <link rel="alternate"> elements whose type is
+// application/rss+xml, application/atom+xml, or application/feed+json (for
+// JSON Feed). We resolve their hrefs against baseURL, which should be the URL
+// of the page, or against the page's <base href> if it has one. baseURL must
+// be absolute.
+//
+// We return the URLs in the order the page lists them, without duplicates. If
+// the page doesn't advertise any feeds, we return none and no error.
+func DiscoverFeeds(htmlBody []byte, baseURL string) ([]string, error) {
+	u, err := url.Parse(strings.TrimSpace(baseURL))
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing base URL")
+	}
+	if !u.IsAbs() {
+		return nil, errors.New("base URL is not absolute")
+	}
+	base := u.String()
+
+	r, err := charset.NewReader(bytes.NewReader(htmlBody), "")
+	if err != nil {
+		return nil, errors.Wrap(err, "error determining charset")
+	}
+
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing HTML")
+	}
+
+	// Only the first <base href> counts. It applies to the whole document, so
+	// find it before we resolve anything.
+	var links []*html.Node
+	foundBase := false
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Base:
+				href := strings.TrimSpace(attrValue(n, "href"))
+				if !foundBase && href != "" {
+					foundBase = true
+					if resolved := resolveURL(base, href); resolved != "" {
+						base = resolved
+					}
+				}
+			case atom.Link:
+				links = append(links, n)
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	var feeds []string
+	for _, n := range links {
+		if !isFeedLink(n) {
+			continue
+		}
+		feedURL := resolveURL(base, attrValue(n, "href"))
+		if feedURL == "" || containsString(feeds, feedURL) {
+			continue
+		}
+		feeds = append(feeds, feedURL)
+	}
+
+	return feeds, nil
+}
+
+// isFeedLink decides whether a <link> element advertises a feed. Its rel must
+// include alternate, and its type must be one in feedMediaTypes.
+func isFeedLink(n *html.Node) bool {
+	alternate := false
+	for _, rel := range strings.Fields(attrValue(n, "rel")) {
+		if strings.EqualFold(rel, "alternate") {
+			alternate = true
+			break
+		}
+	}
+	if !alternate {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(attrValue(n, "type"))
+	if err != nil {
+		return false
+	}
+	return containsString(feedMediaTypes, mediaType)
+}
@@ -1,22 +1,21 @@
 package rss
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 )
 
 // The input types (rssXML, rssChannelXML, rssItemXML) include less fields
 // than I write out. To keep the decoding side from getting overcomplicated
 // vs. the encoding side, use different types here.
-//
-// Differences:
-//
-// LastBuildDate is not in rssChannelXML
-//
-// GUID is not in rssItemXML
 
 // <rss version="2.0">
 //   <channel> Info about the feed, and its items
@@ -24,35 +23,113 @@ type outXML struct {
 	XMLName xml.Name      `xml:"rss"`
 	Version string        `xml:"version,attr"`
 	Channel outChannelXML `xml:"channel"`
+
+	// Namespace declarations. These are only set if we use the namespace.
+	XMLNSITunes  string `xml:"xmlns:itunes,attr,omitempty"`
+	XMLNSAtom    string `xml:"xmlns:atom,attr,omitempty"`
+	XMLNSContent string `xml:"xmlns:content,attr,omitempty"`
 }
 
 // <channel>
 //   <title>         Channel title
 //   <link>          URL corresponding to channel
 //   <description>   Phrase describing the channel
+//   <language>      Language the channel is written in (optional)
 //   <pubDate>       Publication date for the content
 //   <lastBuildDate> Last time content of channel changed
+//   <category>      Categories the channel belongs to (optional)
+//   <generator>     Software that made the channel (optional)
+//   <image>         Artwork for the channel (optional)
+//   <atom:link>     URL of the feed itself, with rel=self (optional)
+//   <itunes:*>      Podcast information (optional)
 type outChannelXML struct {
-	Title         string       `xml:"title"`
-	Link          string       `xml:"link"`
-	Description   string       `xml:"description"`
-	PubDate       string       `xml:"pubDate"`
-	LastBuildDate string       `xml:"lastBuildDate"`
-	Items         []outItemXML `xml:"item"`
+	Title         string           `xml:"title"`
+	Link          string           `xml:"link"`
+	Description   string           `xml:"description"`
+	Language      string           `xml:"language,omitempty"`
+	PubDate       string           `xml:"pubDate,omitempty"`
+	LastBuildDate string           `xml:"lastBuildDate,omitempty"`
+	Categories    []outCategoryXML `xml:"category"`
+	Generator     string           `xml:"generator,omitempty"`
+	Image         *outImageXML     `xml:"image"`
+
+	AtomLinks []outAtomLinkXML `xml:"atom:link"`
+
+	ITunesOwner *outITunesOwnerXML `xml:"itunes:owner"`
+	ITunesType  string             `xml:"itunes:type,omitempty"`
+
+	Items []outItemXML `xml:"item"`
 }
 
-// <item>
-//   <title>       Title of the item
-//   <link>        URL of the item
-//   <description> Item synopsis
-//   <pubDate>     When the item was published
-//   <guid>        Arbitrary string unique to the item
-type outItemXML struct {
+// <category domain="...">Name</category>
+type outCategoryXML struct {
+	Domain string `xml:"domain,attr,omitempty"`
+	Name   string `xml:",chardata"`
+}
+
+// <image>
+//   <url>         URL of the image
+//   <title>       Describes the image
+//   <link>        URL the image links to
+//   <description> Title text for the link (optional)
+//   <width>       Width in pixels (optional)
+//   <height>      Height in pixels (optional)
+type outImageXML struct {
+	URL         string `xml:"url"`
 	Title       string `xml:"title"`
 	Link        string `xml:"link"`
-	Description string `xml:"description"`
-	PubDate     string `xml:"pubDate"`
-	GUID        string `xml:"guid"`
+	Description string `xml:"description,omitempty"`
+	Width       int    `xml:"width,omitempty"`
+	Height      int    `xml:"height,omitempty"`
+}
+
+// <itunes:owner>
+//   <itunes:name>  Name of the podcast owner
+//   <itunes:email> Email of the podcast owner
+type outITunesOwnerXML struct {
+	Name  string `xml:"itunes:name,omitempty"`
+	Email string `xml:"itunes:email,omitempty"`
+}
+
+// <item>
+//   <title>           Title of the item
+//   <link>            URL of the item
+//   <description>     Item synopsis
+//   <content:encoded> Full content of the item (optional)
+//   <author>          Who wrote the item (optional)
+//   <pubDate>         When the item was published
+//   <category>        Categories the item belongs to (optional)
+//   <enclosure>       Media files attached to the item (optional)
+//   <guid>            Arbitrary string unique to the item (optional)
+type outItemXML struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	Description string       `xml:"description"`
+	Content     *outCDATAXML `xml:"content:encoded"`
+	Author      string       `xml:"author,omitempty"`
+	PubDate     string       `xml:"pubDate,omitempty"`
+	GUID        *outGUIDXML  `xml:"guid"`
+
+	Categories []outCategoryXML  `xml:"category"`
+	Enclosures []outEnclosureXML `xml:"enclosure"`
+}
+
+// <![CDATA[...]]>
+type outCDATAXML struct {
+	Value string `xml:",cdata"`
+}
+
+// <guid isPermaLink="...">GUID</guid>
+type outGUIDXML struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// <enclosure url="..." length="..." type="..."/>
+type outEnclosureXML struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
 }
 
 // WriteFeedXML takes a Feed and generates and writes an XML file.
@@ -90,6 +167,22 @@ func WriteFeedXML(feed Feed, filename string) error {
 
 // Turn the feed into XML.
 func makeXML(feed Feed) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := encodeXML(buf, feed); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeXML writes the feed as RSS XML to w. It doesn't build the document in
+// memory first.
+func encodeXML(w io.Writer, feed Feed) error {
+	if config.StrictRFC {
+		if err := validateStrict(feed); err != nil {
+			return err
+		}
+	}
+
 	out := outXML{
 		// Version is required. We use 2.0 even though we are generating 2.0.1 as
 		// that, it seems, is the spec.
@@ -98,39 +191,252 @@ func makeXML(feed Feed) ([]byte, error) {
 			Title:       feed.Title,
 			Link:        feed.Link,
 			Description: feed.Description,
-			// TODO: These dates could/should be different.
-			PubDate:       feed.PubDate.Format(time.RFC1123Z),
-			LastBuildDate: feed.PubDate.Format(time.RFC1123Z),
+			Language:    feed.Language,
+			PubDate:     formatRSSTime(feed.PubDate),
 		},
 	}
 
+	lastBuildDate := feed.LastBuildDate
+	if lastBuildDate.IsZero() {
+		lastBuildDate = feed.PubDate
+	}
+	out.Channel.LastBuildDate = formatRSSTime(lastBuildDate)
+
+	if config.AutoLastBuildDate {
+		if latest := feed.LatestItemDate(); !latest.IsZero() {
+			out.Channel.LastBuildDate = formatRSSTime(latest)
+		}
+	}
+
+	out.Channel.Categories = makeCategoriesXML(feed.Categories)
+
+	if generator := feedGenerator(feed); generator != nil {
+		out.Channel.Generator = generator.Name
+	}
+
+	if feed.Image != nil {
+		out.Channel.Image = makeImageXML(feed)
+	}
+
+	// Validators and WebSub hubs expect feeds to say where they live.
+	if self := strings.TrimSpace(feed.Self); self != "" {
+		out.XMLNSAtom = atomNS
+		out.Channel.AtomLinks = append(out.Channel.AtomLinks, outAtomLinkXML{
+			Href: self,
+			Rel:  "self",
+			Type: "application/rss+xml",
+		})
+	}
+
+	if feed.ITunes != nil {
+		out.XMLNSITunes = itunesNS
+		out.Channel.ITunesType = feed.ITunes.Type
+		if feed.ITunes.OwnerName != "" || feed.ITunes.OwnerEmail != "" {
+			out.Channel.ITunesOwner = &outITunesOwnerXML{
+				Name:  feed.ITunes.OwnerName,
+				Email: feed.ITunes.OwnerEmail,
+			}
+		}
+	}
+
 	for _, item := range feed.Items {
-		out.Channel.Items = append(out.Channel.Items, outItemXML{
+		outItem := outItemXML{
 			Title:       item.Title,
 			Link:        item.Link,
 			Description: item.Description,
-			PubDate:     item.PubDate.Format(time.RFC1123Z),
-			// Use the URI as GUID. It should be uniquely identifying the post after
-			// all. Note the GUID has no required format other than it is intended to
-			// be unique.
-			GUID: item.Link,
+			Author:      item.Author,
+			PubDate:     formatRSSTime(item.PubDate),
+			GUID:        makeGUIDXML(item),
+			Categories:  makeCategoriesXML(item.Categories),
+			Enclosures:  makeEnclosuresXML(item.Enclosures),
+		}
+		// The content is usually HTML. CDATA keeps it readable.
+		if item.Content != "" {
+			out.XMLNSContent = contentNS
+			outItem.Content = &outCDATAXML{Value: item.Content}
+		}
+		out.Channel.Items = append(out.Channel.Items, outItem)
+	}
+
+	return encodeDocument(w, out)
+}
+
+// encodeDocument writes the XML header <?xml .. ?> and then the document.
+func encodeDocument(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("failed to write xml: %s", err)
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to marshal xml: %s", err)
+	}
+
+	return nil
+}
+
+// formatRSSTime formats a time for an RSS date element. If the time is zero
+// it returns blank so we omit the element. Otherwise we would write a date in
+// the year 1 which validators reject.
+//
+// With the StrictRFC setting we write the time in GMT.
+func formatRSSTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	if config.StrictRFC {
+		return t.UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+	}
+	return t.Format(time.RFC1123Z)
+}
+
+// makeImageXML converts the feed's image to an <image> element. RSS requires a
+// title and link, but images from Atom feeds only have a URL. For those we
+// use the feed's, which is what RSS says they should usually be anyway.
+func makeImageXML(feed Feed) *outImageXML {
+	image := &outImageXML{
+		URL:         feed.Image.URL,
+		Title:       feed.Image.Title,
+		Link:        feed.Image.Link,
+		Description: feed.Image.Description,
+		Width:       feed.Image.Width,
+		Height:      feed.Image.Height,
+	}
+	if image.Title == "" {
+		image.Title = feed.Title
+	}
+	if image.Link == "" {
+		image.Link = feed.Link
+	}
+	return image
+}
+
+// makeEnclosuresXML converts enclosures to <enclosure> elements. RSS requires
+// all three attributes, so we always write them.
+func makeEnclosuresXML(enclosures []Enclosure) []outEnclosureXML {
+	var out []outEnclosureXML
+	for _, enclosure := range enclosures {
+		out = append(out, outEnclosureXML{
+			URL:    enclosure.URL,
+			Length: enclosure.Length,
+			Type:   enclosure.Type,
 		})
 	}
+	return out
+}
+
+// itemGUID decides what to write as an item's <guid>. See GUIDStrategy. If it
+// returns blank we omit the element.
+//
+// isPermaLink says whether the GUID is a URL to the item.
+func itemGUID(item Item) (guid string, isPermaLink bool) {
+	if item.GUID != "" || config.GUIDStrategy == GUIDOmit {
+		if item.GUIDIsPermaLink != nil {
+			return item.GUID, *item.GUIDIsPermaLink
+		}
+		// We don't know. Since we always write the attribute, only claim it is
+		// a permalink if it looks like one.
+		return item.GUID, isHTTPURL(item.GUID)
+	}
+
+	// Use the URI as GUID. It should be uniquely identifying the post after
+	// all. Note the GUID has no required format other than it is intended to be
+	// unique.
+	return item.Link, true
+}
 
-	// Convert to XML.
-	xmlBody, err := xml.MarshalIndent(out, "", "  ")
+// isHTTPURL reports whether s is an absolute http or https URL.
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal xml: %s", err)
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// makeGUIDXML converts the item's GUID to a <guid> element. We always write
+// the isPermaLink attribute so consumers don't have to rely on its default.
+// It returns nil if there is no GUID to write.
+func makeGUIDXML(item Item) *outGUIDXML {
+	guid, isPermaLink := itemGUID(item)
+	if guid == "" {
+		return nil
+	}
+	return &outGUIDXML{
+		IsPermaLink: isPermaLink,
+		Value:       guid,
+	}
+}
+
+// makeCategoriesXML converts categories to <category> elements. If the
+// CanonicalOrder setting is on we sort them.
+func makeCategoriesXML(categories []Category) []outCategoryXML {
+	var out []outCategoryXML
+	for _, category := range categories {
+		out = append(out, outCategoryXML{
+			Domain: category.Domain,
+			Name:   category.Name,
+		})
 	}
 
-	// Put document together.
+	if config.CanonicalOrder {
+		sort.SliceStable(out, func(i, j int) bool {
+			if out[i].Domain != out[j].Domain {
+				return out[i].Domain < out[j].Domain
+			}
+			return out[i].Name < out[j].Name
+		})
+	}
 
-	var xmlDoc []byte
+	return out
+}
 
-	// Add the XML header <?xml .. ?>
-	xmlHeader := []byte(xml.Header)
-	xmlDoc = append(xmlDoc, xmlHeader...)
-	xmlDoc = append(xmlDoc, xmlBody...)
+// feedGenerator returns the generator to write for the feed. This is the
+// feed's own if it has one, otherwise one named by the DefaultGenerator
+// setting. It returns nil if there is neither.
+func feedGenerator(feed Feed) *Generator {
+	if feed.Generator != nil {
+		return feed.Generator
+	}
+	if config.DefaultGenerator != "" {
+		return &Generator{Name: config.DefaultGenerator}
+	}
+	return nil
+}
+
+// EncodedSize returns how many bytes the feed is when encoded. format is rss
+// (as WriteFeedXML writes) or atom (as WriteAtomXML writes). This is useful
+// for a Content-Length header or a quota check.
+//
+// We encode the feed but discard the bytes rather than keeping the encoded
+// document. We still build the structure we encode from, with every item, so
+// this uses about as much memory as encoding the feed for real.
+func (f *Feed) EncodedSize(format string) (int, error) {
+	counter := &countingWriter{}
+
+	switch format {
+	case "rss":
+		if err := encodeXML(counter, *f); err != nil {
+			return 0, err
+		}
+	case "atom":
+		if err := encodeAtomXML(counter, *f); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unknown format: %s", format)
+	}
+
+	return counter.n, nil
+}
+
+// countingWriter is an io.Writer that counts and discards what it is given.
+type countingWriter struct {
+	n int
+}
 
-	return xmlDoc, nil
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
 }
@@ -0,0 +1,293 @@
+package rss
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// <feed xmlns="http://www.w3.org/2005/Atom">
+//   <title>     Feed title
+//   <subtitle>  Phrase describing the feed
+//   <link>      URL corresponding to the feed
+//   <updated>   Last time the feed changed
+//   <id>        Permanent, unique identifier of the feed
+//   <author>    Who is responsible for the feed (optional)
+//   <generator> Software that made the feed (optional)
+//   <entry>     Items
+type outAtomXML struct {
+	XMLName   xml.Name             `xml:"http://www.w3.org/2005/Atom feed"`
+	Title     string               `xml:"title"`
+	Subtitle  *outAtomContentXML   `xml:"subtitle"`
+	Links     []outAtomLinkXML     `xml:"link"`
+	Updated   string               `xml:"updated"`
+	ID        string               `xml:"id"`
+	Author    *outAtomPersonXML    `xml:"author"`
+	Generator *outAtomGeneratorXML `xml:"generator"`
+	Entries   []outAtomEntryXML    `xml:"entry"`
+}
+
+// <author>
+//   <name> Name of the person
+type outAtomPersonXML struct {
+	Name string `xml:"name"`
+}
+
+// <generator uri="..." version="...">Name</generator>
+type outAtomGeneratorXML struct {
+	URI     string `xml:"uri,attr,omitempty"`
+	Version string `xml:"version,attr,omitempty"`
+	Name    string `xml:",chardata"`
+}
+
+// <link href="..." rel="..." type="..." length="..."/>
+type outAtomLinkXML struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+// <entry>
+//   <title>   Title of the entry
+//   <link>    URL of the entry
+//   <id>      Permanent, unique identifier of the entry
+//   <updated> Last time the entry changed
+//   <author>  Who wrote the entry (optional)
+//   <summary> Short version of the entry (optional)
+//   <content> Entry content
+type outAtomEntryXML struct {
+	Title   string             `xml:"title"`
+	Links   []outAtomLinkXML   `xml:"link"`
+	ID      string             `xml:"id"`
+	Updated string             `xml:"updated"`
+	Author  *outAtomPersonXML  `xml:"author"`
+	Summary *outAtomContentXML `xml:"summary"`
+	Content *outAtomContentXML `xml:"content"`
+}
+
+// <content type="html">...</content> or <summary type="html">...</summary>
+type outAtomContentXML struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// WriteAtomXML takes a Feed and generates and writes an Atom XML file.
+//
+// See https://tools.ietf.org/html/rfc4287
+//
+// You can validate the output files using:
+// https://validator.w3.org/feed/
+//
+// Overall the XML structure is:
+// <feed><entry></entry><entry></entry>...</feed>
+//
+// Atom requires the feed and every entry to have an <id>. We use Feed.ID and
+// Item.GUID. If those are blank we generate an ID from the link. See atomID()
+// and atomEntryID() for how.
+//
+// Atom also requires an <updated> date. If the feed has no PubDate we use its
+// LastBuildDate, then the newest item's date, and if there are no dates at all
+// the Unix epoch. If an item has no date we use the feed's.
+func WriteAtomXML(feed Feed, filename string) error {
+	xmlDoc, err := makeAtomXML(feed)
+	if err != nil {
+		return fmt.Errorf("unable to generate Atom XML: %s", err)
+	}
+
+	err = ioutil.WriteFile(filename, xmlDoc, 0644)
+	if err != nil {
+		log.Printf("Failed to write file [%s]: %s", filename, err)
+		return err
+	}
+
+	if config.Verbose {
+		log.Printf("Wrote file [%s]", filename)
+	}
+
+	return nil
+}
+
+// Turn the feed into Atom XML.
+func makeAtomXML(feed Feed) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := encodeAtomXML(buf, feed); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeAtomXML writes the feed as Atom XML to w.
+func encodeAtomXML(w io.Writer, feed Feed) error {
+	updated := atomFeedUpdated(feed)
+
+	out := outAtomXML{
+		Title:   feed.Title,
+		Updated: updated.Format(time.RFC3339),
+		ID:      atomID(feed.ID, feed.Link, feed.Title),
+		Author:  makeAtomPersonXML(feed.Author),
+	}
+
+	if feed.Description != "" {
+		out.Subtitle = &outAtomContentXML{
+			Type:  "html",
+			Value: feed.Description,
+		}
+	}
+
+	if feed.Link != "" {
+		out.Links = append(out.Links, outAtomLinkXML{
+			Href: feed.Link,
+			Rel:  "alternate",
+		})
+	}
+	if feed.Self != "" {
+		out.Links = append(out.Links, outAtomLinkXML{
+			Href: feed.Self,
+			Rel:  "self",
+		})
+	}
+
+	if generator := feedGenerator(feed); generator != nil {
+		out.Generator = &outAtomGeneratorXML{
+			URI:     generator.URI,
+			Version: generator.Version,
+			Name:    generator.Name,
+		}
+	}
+
+	for i, item := range feed.Items {
+		entryUpdated := item.PubDate
+		if entryUpdated.IsZero() {
+			entryUpdated = updated
+		}
+
+		entry := outAtomEntryXML{
+			Title:   item.Title,
+			ID:      atomEntryID(item, i),
+			Updated: entryUpdated.Format(time.RFC3339),
+			Author:  makeAtomPersonXML(item.Author),
+		}
+
+		if item.Link != "" {
+			entry.Links = append(entry.Links, outAtomLinkXML{
+				Href: item.Link,
+				Rel:  "alternate",
+			})
+		}
+
+		for _, enclosure := range item.Enclosures {
+			entry.Links = append(entry.Links, outAtomLinkXML{
+				Href:   enclosure.URL,
+				Rel:    "enclosure",
+				Type:   enclosure.Type,
+				Length: enclosure.Length,
+			})
+		}
+
+		if item.Summary != "" {
+			entry.Summary = &outAtomContentXML{
+				Type:  "html",
+				Value: item.Summary,
+			}
+		}
+
+		if item.Description != "" {
+			entry.Content = &outAtomContentXML{
+				Type:  "html",
+				Value: item.Description,
+			}
+		}
+
+		out.Entries = append(out.Entries, entry)
+	}
+
+	return encodeDocument(w, out)
+}
+
+// atomFeedUpdated returns the date to use for the feed's <updated>. This is
+// the feed's PubDate, or if it doesn't have one, its LastBuildDate, or the
+// newest item's date.
+//
+// If there are no dates at all we use the Unix epoch. We don't use the
+// current time as then the output would change every time we write it, and
+// readers would think every undated entry had been updated.
+func atomFeedUpdated(feed Feed) time.Time {
+	if !feed.PubDate.IsZero() {
+		return feed.PubDate
+	}
+	if !feed.LastBuildDate.IsZero() {
+		return feed.LastBuildDate
+	}
+	if latest := feed.LatestItemDate(); !latest.IsZero() {
+		return latest
+	}
+	return time.Unix(0, 0).UTC()
+}
+
+// makeAtomPersonXML converts a name to an <author> element. It returns nil if
+// the name is blank.
+func makeAtomPersonXML(name string) *outAtomPersonXML {
+	if name == "" {
+		return nil
+	}
+	return &outAtomPersonXML{Name: name}
+}
+
+// atomID returns the <id> to use for a feed or entry.
+//
+// If we have an ID, we use it. Otherwise we generate a urn:uuid: ID from the
+// link, or from the title if there is no link. The UUID is a version 5 (name
+// based, SHA-1) UUID in the URL namespace (RFC 4122), so the same link always
+// gives the same ID, across runs and machines.
+func atomID(id, link, title string) string {
+	if id != "" {
+		return id
+	}
+
+	name := link
+	if name == "" {
+		name = title
+	}
+
+	return "urn:uuid:" + uuidV5(uuidNamespaceURL, name)
+}
+
+// atomEntryID returns the <id> to use for the entry for the item at index i.
+//
+// This is atomID() except for items with none of GUID, link, or title. Those
+// would all get the same ID, but Atom requires entry IDs to be unique. For
+// them we generate the ID from the item's position, date, and description.
+func atomEntryID(item Item, i int) string {
+	if item.GUID != "" || item.Link != "" || item.Title != "" {
+		return atomID(item.GUID, item.Link, item.Title)
+	}
+
+	name := fmt.Sprintf("entry %d\x00%s\x00%s", i,
+		item.PubDate.UTC().Format(time.RFC3339Nano), item.Description)
+	return "urn:uuid:" + uuidV5(uuidNamespaceURL, name)
+}
+
+// uuidNamespaceURL is the RFC 4122 namespace for names that are URLs.
+var uuidNamespaceURL = [16]byte{
+	0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1,
+	0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8,
+}
+
+// uuidV5 generates a version 5 UUID as described in RFC 4122 section 4.3.
+func uuidV5(namespace [16]byte, name string) string {
+	sum := sha1.Sum(append(namespace[:], name...))
+
+	var u [16]byte
+	copy(u[:], sum[:])
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10],
+		u[10:16])
+}
@@ -0,0 +1,139 @@
+package rss
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// jsonFeedVersion is the version of JSON Feed we write.
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+
+// outJSONFeedJSON is a JSON Feed document. We use it for encoding.
+type outJSONFeedJSON struct {
+	Version     string                  `json:"version"`
+	Title       string                  `json:"title"`
+	HomePageURL string                  `json:"home_page_url,omitempty"`
+	FeedURL     string                  `json:"feed_url,omitempty"`
+	Description string                  `json:"description,omitempty"`
+	Authors     []outJSONFeedAuthorJSON `json:"authors,omitempty"`
+
+	// Items must be an array even if there are none.
+	Items []outJSONFeedItemJSON `json:"items"`
+}
+
+// outJSONFeedItemJSON is an item in a JSON Feed.
+type outJSONFeedItemJSON struct {
+	ID            string                      `json:"id"`
+	URL           string                      `json:"url,omitempty"`
+	Title         string                      `json:"title,omitempty"`
+	ContentHTML   string                      `json:"content_html"`
+	Summary       string                      `json:"summary,omitempty"`
+	DatePublished string                      `json:"date_published,omitempty"`
+	Authors       []outJSONFeedAuthorJSON     `json:"authors,omitempty"`
+	Tags          []string                    `json:"tags,omitempty"`
+	Attachments   []outJSONFeedAttachmentJSON `json:"attachments,omitempty"`
+}
+
+// outJSONFeedAuthorJSON is an author object.
+type outJSONFeedAuthorJSON struct {
+	Name string `json:"name"`
+}
+
+// outJSONFeedAttachmentJSON is an item's attachment, such as a podcast
+// episode's audio.
+type outJSONFeedAttachmentJSON struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+// MarshalJSONFeed encodes the feed as JSON Feed 1.1.
+//
+// See https://jsonfeed.org/version/1.1
+//
+// Link becomes home_page_url and Self feed_url. Each item's id is its GUID,
+// or its link if it has none. If it has neither we generate one from its
+// title the same way as we do for Atom. See atomID(). The item's Description
+// becomes its content_html.
+func (f Feed) MarshalJSONFeed() ([]byte, error) {
+	out := outJSONFeedJSON{
+		Version:     jsonFeedVersion,
+		Title:       f.Title,
+		HomePageURL: f.Link,
+		FeedURL:     f.Self,
+		Description: f.Description,
+		Authors:     makeJSONFeedAuthors(f.Author),
+		Items:       []outJSONFeedItemJSON{},
+	}
+
+	for _, item := range f.Items {
+		outItem := outJSONFeedItemJSON{
+			ID:            jsonFeedItemID(item),
+			URL:           item.Link,
+			Title:         item.Title,
+			ContentHTML:   item.Description,
+			Summary:       item.Summary,
+			DatePublished: formatJSONFeedTime(item.PubDate),
+			Authors:       makeJSONFeedAuthors(item.Author),
+		}
+
+		for _, category := range item.Categories {
+			outItem.Tags = append(outItem.Tags, category.Name)
+		}
+
+		for _, enclosure := range item.Enclosures {
+			outItem.Attachments = append(outItem.Attachments,
+				outJSONFeedAttachmentJSON{
+					URL:         enclosure.URL,
+					MimeType:    enclosure.Type,
+					SizeInBytes: enclosure.Length,
+				})
+		}
+
+		out.Items = append(out.Items, outItem)
+	}
+
+	// Don't escape HTML. The content is HTML and escaping it makes it harder
+	// to read for no benefit.
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(out); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal JSON")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// jsonFeedItemID returns the id to write for an item. See MarshalJSONFeed.
+func jsonFeedItemID(item Item) string {
+	if item.GUID != "" {
+		return item.GUID
+	}
+	if item.Link != "" {
+		return item.Link
+	}
+	return atomID("", "", item.Title)
+}
+
+// makeJSONFeedAuthors converts a name to an authors array. It returns nil if
+// the name is blank.
+func makeJSONFeedAuthors(name string) []outJSONFeedAuthorJSON {
+	if name == "" {
+		return nil
+	}
+	return []outJSONFeedAuthorJSON{{Name: name}}
+}
+
+// formatJSONFeedTime formats a time for JSON Feed. If the time is zero it
+// returns blank so we omit it.
+func formatJSONFeedTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
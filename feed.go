@@ -0,0 +1,429 @@
+package rss
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Update merges the items from a newer copy of the feed into this one. This is
+// useful when you fetch a feed again and want to keep what you already have.
+//
+// Items are matched by GUID, falling back to link and then title if an item
+// has no GUID. For an item in both feeds we keep whichever version has the
+// later PubDate (for Atom this is the entry's <updated>), so edited items
+// replace their old versions. If the dates are the same we take the version
+// from newer as it is the more recently fetched. Items only in newer are
+// added, and items only in f are kept.
+//
+// Afterwards Items is sorted by PubDate, newest first.
+//
+// Only items are merged. The feed's own metadata (title, link, etc) is not
+// changed.
+func (f *Feed) Update(newer *Feed) {
+	if newer == nil {
+		return
+	}
+
+	indexes := map[string]int{}
+	for i, item := range f.Items {
+		key := itemKey(item)
+		if key == "" {
+			continue
+		}
+		indexes[key] = i
+	}
+
+	for _, item := range newer.Items {
+		key := itemKey(item)
+		i, ok := indexes[key]
+		if key == "" || !ok {
+			f.Items = append(f.Items, item)
+			if key != "" {
+				indexes[key] = len(f.Items) - 1
+			}
+			continue
+		}
+
+		if item.PubDate.Before(f.Items[i].PubDate) {
+			continue
+		}
+		f.Items[i] = item
+	}
+
+	sortItemsNewestFirst(f.Items)
+}
+
+// MergeFeeds combines several feeds into one. This is useful when you
+// aggregate mirrors of the same source, or feeds that share items.
+//
+// The merged feed's metadata (title, link, etc) comes from the first feed that
+// isn't nil. Its items are those of all the feeds with duplicates removed. We
+// match items the same way Update() does, by GUID, falling back to link and
+// then title. Unlike Update(), we keep the first instance of an item we see,
+// taking the feeds in the order given. Items with none of GUID, link, or title
+// are all kept.
+//
+// The items are sorted by PubDate, newest first. It returns nil if every feed
+// is nil.
+func MergeFeeds(feeds ...*Feed) *Feed {
+	var merged *Feed
+	seen := map[string]struct{}{}
+	for _, feed := range feeds {
+		if feed == nil {
+			continue
+		}
+		if merged == nil {
+			metadata := *feed
+			metadata.Items = nil
+			merged = &metadata
+		}
+
+		for _, item := range feed.Items {
+			key := itemKey(item)
+			if key != "" {
+				if _, ok := seen[key]; ok {
+					continue
+				}
+				seen[key] = struct{}{}
+			}
+			merged.Items = append(merged.Items, item)
+		}
+	}
+
+	if merged != nil {
+		sortItemsNewestFirst(merged.Items)
+	}
+	return merged
+}
+
+// itemKey returns the string we use to tell if two items are the same item.
+// This is the GUID if there is one, otherwise the link, otherwise the title.
+func itemKey(item Item) string {
+	if item.GUID != "" {
+		return item.GUID
+	}
+	if item.Link != "" {
+		return item.Link
+	}
+	return item.Title
+}
+
+// sortItemsNewestFirst sorts items by PubDate, newest first. Items with the
+// same date keep their order.
+func sortItemsNewestFirst(items []Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].PubDate.After(items[j].PubDate)
+	})
+}
+
+// ClearItems removes the feed's items, keeping its own metadata (title, link,
+// etc). This lets the items be garbage collected, which is useful if you hold
+// many feeds but only need their metadata, such as to build an OPML file.
+func (f *Feed) ClearItems() {
+	f.Items = nil
+}
+
+// UpdateInterval estimates how often the feed publishes. This is useful for
+// deciding how often to poll it.
+//
+// It is the median of the gaps between consecutive item dates. We use the
+// median rather than the mean so a single long break (or a burst of items)
+// doesn't skew the result. Items without a date are ignored. We need at least
+// two dated items. If there are fewer, it returns zero.
+func (f *Feed) UpdateInterval() time.Duration {
+	var dates []time.Time
+	for _, item := range f.Items {
+		if item.PubDate.IsZero() {
+			continue
+		}
+		dates = append(dates, item.PubDate)
+	}
+
+	if len(dates) < 2 {
+		return 0
+	}
+
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	var gaps []time.Duration
+	for i := 1; i < len(dates); i++ {
+		gaps = append(gaps, dates[i].Sub(dates[i-1]))
+	}
+
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+
+	middle := len(gaps) / 2
+	if len(gaps)%2 == 1 {
+		return gaps[middle]
+	}
+	return (gaps[middle-1] + gaps[middle]) / 2
+}
+
+// IsStale reports whether the feed seems to have stopped updating. That is, its
+// newest date is older than threshold before now. The newest date is the
+// latest of the item dates and the feed's PubDate.
+//
+// Zero dates mean the feed didn't give one, so we ignore them. If there are no
+// dates at all we can't tell, and we return false.
+func (f *Feed) IsStale(threshold time.Duration, now time.Time) bool {
+	newest := f.PubDate
+	for _, item := range f.Items {
+		if item.PubDate.After(newest) {
+			newest = item.PubDate
+		}
+	}
+
+	if newest.IsZero() {
+		return false
+	}
+
+	return newest.Before(now.Add(-threshold))
+}
+
+// LatestItemDate returns the newest PubDate of the feed's items. It returns
+// the zero time if no item has a date.
+func (f *Feed) LatestItemDate() time.Time {
+	var latest time.Time
+	for _, item := range f.Items {
+		if item.PubDate.After(latest) {
+			latest = item.PubDate
+		}
+	}
+	return latest
+}
+
+// CanonicalURL returns a normalized URL for the feed. This is useful as a key
+// to tell if two subscriptions are for the same feed.
+//
+// We use Self if the feed has it, and otherwise Link. We normalize the URL by
+// lowercasing the scheme and host, removing the port if it is the default for
+// the scheme, removing any fragment, and removing a trailing slash from the
+// path. If the URL doesn't parse, we return it with surrounding whitespace
+// removed.
+func (f *Feed) CanonicalURL() string {
+	raw := strings.TrimSpace(f.Self)
+	if raw == "" {
+		raw = strings.TrimSpace(f.Link)
+	}
+	if raw == "" {
+		return ""
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+
+	normalizeURL(u)
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+
+	return u.String()
+}
+
+// normalizeURL lowercases the URL's scheme and host, removes the port if it is
+// the default for the scheme, and removes any fragment.
+func normalizeURL(u *url.URL) {
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") ||
+		(u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+		if strings.Contains(u.Host, ":") {
+			u.Host = "[" + u.Host + "]"
+		}
+	}
+	u.Fragment = ""
+}
+
+// SuggestedInterval returns how often to poll the feed according to the feed's
+// syndication module elements. This is the update period divided by the
+// update frequency. For example, an update period of daily with a frequency
+// of 2 gives 12 hours.
+//
+// If only one of the elements is present, we use the module's defaults for
+// the other (daily, and once per period). We take a month to be 30 days and a
+// year to be 365 days. It returns zero if the feed has neither element or the
+// period is not one we know.
+func (f *Feed) SuggestedInterval() time.Duration {
+	if f.UpdatePeriod == "" && f.UpdateFrequency == 0 {
+		return 0
+	}
+
+	day := 24 * time.Hour
+	var period time.Duration
+	switch f.UpdatePeriod {
+	case "hourly":
+		period = time.Hour
+	case "daily", "":
+		period = day
+	case "weekly":
+		period = 7 * day
+	case "monthly":
+		period = 30 * day
+	case "yearly":
+		period = 365 * day
+	default:
+		return 0
+	}
+
+	frequency := f.UpdateFrequency
+	if frequency < 1 {
+		frequency = 1
+	}
+
+	return period / time.Duration(frequency)
+}
+
+// defaultRefreshInterval is what SuggestedRefreshInterval() returns if the
+// feed doesn't say how often to fetch it.
+const defaultRefreshInterval = time.Hour
+
+// SuggestedRefreshInterval returns how long to wait before fetching the feed
+// again. This is its TTL if it has one, and otherwise an hour.
+func (f *Feed) SuggestedRefreshInterval() time.Duration {
+	if f.TTL > 0 {
+		return f.TTL
+	}
+	return defaultRefreshInterval
+}
+
+// FilterByCategory returns a copy of the feed with only the items tagged with
+// any of the given categories. We compare category names case insensitively.
+//
+// We only look at each item's own categories, not the feed's. If no items
+// match, the returned feed has no items.
+func (f *Feed) FilterByCategory(names ...string) *Feed {
+	wanted := map[string]struct{}{}
+	for _, name := range names {
+		wanted[strings.ToLower(strings.TrimSpace(name))] = struct{}{}
+	}
+
+	filtered := *f
+	filtered.Items = nil
+	for _, item := range f.Items {
+		for _, category := range item.Categories {
+			if _, ok := wanted[strings.ToLower(category.Name)]; ok {
+				filtered.Items = append(filtered.Items, item)
+				break
+			}
+		}
+	}
+
+	return &filtered
+}
+
+// ItemsBetween returns the items published in the window [start, end). That
+// is, an item dated exactly start is included, but one dated exactly end is
+// not. This makes it easy to cover consecutive periods, such as one week
+// after another, without an item appearing in two of them.
+//
+// Items without a date (a zero PubDate) are never included.
+func (f *Feed) ItemsBetween(start, end time.Time) []Item {
+	var items []Item
+	for _, item := range f.Items {
+		if item.PubDate.IsZero() {
+			continue
+		}
+		if item.PubDate.Before(start) || !item.PubDate.Before(end) {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// defaultTitleSimilarity is the threshold DedupeByTitle() uses if it isn't
+// given a valid one.
+const defaultTitleSimilarity = 0.8
+
+// DedupeByTitle removes items whose titles are nearly the same as another
+// item's. This is useful when aggregating feeds, where the same story can
+// appear with different links and GUIDs. For exact duplicates, see Update().
+//
+// We compare titles as sets of words: we lowercase them and split them on
+// anything that isn't a letter or digit. Two titles' similarity is the number
+// of words they share divided by the number of distinct words in both (the
+// Jaccard index). It is 1 if they have the same words and 0 if they have none
+// in common. Items are duplicates if their similarity is at least threshold.
+// If threshold is not in (0, 1] we use 0.8.
+//
+// Of duplicates, we keep the earliest item by PubDate. Items without a date
+// count as later than any with one. We never remove items without a title.
+// The items we keep stay in their original order.
+func (f *Feed) DedupeByTitle(threshold float64) {
+	if threshold <= 0 || threshold > 1 {
+		threshold = defaultTitleSimilarity
+	}
+
+	order := make([]int, len(f.Items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := f.Items[order[i]].PubDate, f.Items[order[j]].PubDate
+		if a.IsZero() || b.IsZero() {
+			return !a.IsZero() && b.IsZero()
+		}
+		return a.Before(b)
+	})
+
+	var kept []map[string]struct{}
+	removed := map[int]bool{}
+	for _, i := range order {
+		words := titleWords(f.Items[i].Title)
+		if len(words) == 0 {
+			continue
+		}
+
+		duplicate := false
+		for _, other := range kept {
+			if jaccard(words, other) >= threshold {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			removed[i] = true
+			continue
+		}
+		kept = append(kept, words)
+	}
+
+	var items []Item
+	for i, item := range f.Items {
+		if !removed[i] {
+			items = append(items, item)
+		}
+	}
+	f.Items = items
+}
+
+// titleWords returns the set of lowercased words in a title.
+func titleWords(title string) map[string]struct{} {
+	words := map[string]struct{}{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(title),
+		func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		words[word] = struct{}{}
+	}
+	return words
+}
+
+// jaccard returns the Jaccard index of two sets: the size of their
+// intersection divided by the size of their union.
+func jaccard(a, b map[string]struct{}) float64 {
+	shared := 0
+	for word := range a {
+		if _, ok := b[word]; ok {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
@@ -0,0 +1,698 @@
+package rss
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ValidateFeedURL checks a URL is reasonable to fetch a feed from. This is
+// useful for URLs users give you.
+//
+// The URL must be absolute and use http or https. Unless AllowPrivateHosts is
+// set, the host must not be localhost or an IP address that is loopback,
+// private, link-local, multicast, broadcast, or unspecified. This is to stop
+// users having you make requests to your own network.
+//
+// A host that is a number must be an IP address written the usual way. Some
+// resolvers take hosts such as 2130706433 or 0x7f.1 to be 127.0.0.1, so we
+// reject them rather than guess.
+//
+// We don't resolve hostnames, so a name that resolves to a private address
+// passes. To check the address we connect to as well, set the
+// CheckDialedAddress setting, or use DenyPrivateAddresses with your own
+// client.
+//
+// It returns the URL normalized: the scheme and host lowercased, a default
+// port removed, and any fragment removed.
+func ValidateFeedURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", errors.Wrap(err, "invalid URL")
+	}
+
+	normalizeURL(u)
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.Errorf("unsupported URL scheme: %q", u.Scheme)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return "", errors.New("URL has no host")
+	}
+
+	if isNumericHost(host) && net.ParseIP(host) == nil {
+		return "", errors.Errorf("host is not a valid IP address: %s", host)
+	}
+
+	if !config.AllowPrivateHosts && isPrivateHost(host) {
+		return "", errors.Errorf("host is private: %s", host)
+	}
+
+	return u.String(), nil
+}
+
+// privateNetworks are address ranges we consider private. See RFC 1918, RFC
+// 6598, RFC 3927, and RFC 4193. We include multicast and broadcast addresses
+// as they are not for fetching feeds from either.
+var privateNetworks = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"224.0.0.0/4",
+	"255.255.255.255/32",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// isPrivateHost decides whether a hostname or IP address refers to a private
+// or loopback host.
+func isPrivateHost(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return isPrivateIP(ip)
+}
+
+// isPrivateIP decides whether an IP address is in one of privateNetworks.
+func isPrivateIP(ip net.IP) bool {
+	for _, cidr := range privateNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNumericHost decides whether a host is a number rather than a name. Like
+// the URL standard, we look at its last label: if that is a decimal or hex
+// number, the host is an IPv4 address in some form.
+func isNumericHost(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	label := strings.ToLower(host[strings.LastIndex(host, ".")+1:])
+	if label == "" {
+		return false
+	}
+
+	digits := "0123456789"
+	if strings.HasPrefix(label, "0x") {
+		label = label[2:]
+		digits = "0123456789abcdef"
+	}
+	for _, c := range label {
+		if !strings.ContainsRune(digits, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// DenyPrivateAddresses refuses connections to private addresses. Use it as
+// the Control function of a net.Dialer:
+//   dialer := &net.Dialer{Control: rss.DenyPrivateAddresses}
+//   client := &http.Client{
+//     Transport: &http.Transport{DialContext: dialer.DialContext},
+//   }
+//
+// The dialer calls it after resolving the host, so unlike ValidateFeedURL,
+// this catches names that resolve to private addresses, and redirects to
+// them. See ValidateFeedURL for which addresses are private.
+func DenyPrivateAddresses(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrap(err, "invalid address")
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errors.Errorf("address is not an IP address: %s", host)
+	}
+
+	if isPrivateIP(ip) {
+		return errors.Errorf("address is private: %s", ip)
+	}
+	return nil
+}
+
+// publicClient is the client FetchFeed uses by default when the
+// CheckDialedAddress setting is on. It is like http.DefaultClient except it
+// refuses to connect to private addresses. It doesn't use a proxy, as then we
+// would be checking the proxy's address rather than the feed's.
+var publicClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   DenyPrivateAddresses,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	},
+}
+
+// defaultClient returns the client to use if we aren't given one.
+func defaultClient() *http.Client {
+	if config.CheckDialedAddress {
+		return publicClient
+	}
+	return http.DefaultClient
+}
+
+// WebLink is a link from an HTTP Link header. See RFC 8288.
+type WebLink struct {
+	URL string
+
+	// Rels are the link's relation types, lowercased. A link may have more
+	// than one, such as rel="hub self".
+	Rels []string
+
+	// Params holds the link's other parameters, such as title or type. The keys
+	// are lowercased.
+	Params map[string]string
+}
+
+// HasRel reports whether the link has the given relation type.
+func (l WebLink) HasRel(rel string) bool {
+	for _, r := range l.Rels {
+		if r == strings.ToLower(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseLinkHeader parses the value of an HTTP Link header. Publishers may
+// advertise WebSub hubs and the feed's own URL this way. You can pass the
+// values of several Link headers joined with commas.
+//
+// We skip links we can't make sense of rather than failing.
+func ParseLinkHeader(header string) []WebLink {
+	var links []WebLink
+	s := header
+	for {
+		start := strings.Index(s, "<")
+		if start == -1 {
+			return links
+		}
+		end := strings.Index(s[start:], ">")
+		if end == -1 {
+			return links
+		}
+		link := WebLink{
+			URL:    strings.TrimSpace(s[start+1 : start+end]),
+			Params: map[string]string{},
+		}
+		s = s[start+end+1:]
+
+		// Parameters follow until the comma ending the link.
+		for {
+			s = strings.TrimLeft(s, " \t")
+			if !strings.HasPrefix(s, ";") {
+				break
+			}
+			var name, value string
+			name, value, s = parseLinkParam(s[1:])
+			if name == "" {
+				continue
+			}
+			if name == "rel" {
+				link.Rels = strings.Fields(strings.ToLower(value))
+				continue
+			}
+			link.Params[name] = value
+		}
+
+		links = append(links, link)
+
+		comma := strings.Index(s, ",")
+		if comma == -1 {
+			return links
+		}
+		s = s[comma+1:]
+	}
+}
+
+// parseLinkParam parses a name=value parameter from the start of s. The value
+// may be quoted. It returns the lowercased name, the value, and the rest of s.
+func parseLinkParam(s string) (string, string, string) {
+	end := strings.IndexAny(s, "=;,")
+	if end == -1 {
+		return strings.ToLower(strings.TrimSpace(s)), "", ""
+	}
+	name := strings.ToLower(strings.TrimSpace(s[:end]))
+	if s[end] != '=' {
+		return name, "", s[end:]
+	}
+	s = strings.TrimLeft(s[end+1:], " \t")
+
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexAny(s, ";,")
+		if end == -1 {
+			return name, strings.TrimSpace(s), ""
+		}
+		return name, strings.TrimSpace(s[:end]), s[end:]
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				value.WriteByte(s[i])
+			}
+		case '"':
+			return name, value.String(), s[i+1:]
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return name, value.String(), ""
+}
+
+// defaultMaxFetchBytes is the default for the MaxFetchBytes setting.
+const defaultMaxFetchBytes = 10 * 1024 * 1024
+
+// userAgent is the User-Agent we send when fetching feeds.
+const userAgent = "horgh-rss (+https://github.com/horgh/rss)"
+
+// StatusError is the error FetchFeed returns if the server responds with a
+// status other than 2xx. You can use the status code to decide whether to
+// retry.
+type StatusError struct {
+	StatusCode int
+
+	// Status is the status line, such as "404 Not Found".
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status: %s", e.Status)
+}
+
+// FetchFeed fetches a feed over HTTP and parses it. If client is nil we use
+// http.DefaultClient, or if the CheckDialedAddress setting is on, a client
+// that refuses to connect to private addresses. ctx controls cancelling the
+// request.
+//
+// If the response's status is not 2xx we return a *StatusError. We read at
+// most MaxFetchBytes (by default 10 MiB) of the response, and return an error
+// if it is larger.
+//
+// We ask for the response to be compressed with gzip or deflate, and
+// decompress it according to its Content-Encoding header. Some servers
+// compress responses even if we don't ask, so we do this with any client.
+//
+// If the response's Content-Type is application/feed+json or
+// application/json we parse it as a JSON Feed. Otherwise, such as if the
+// Content-Type is missing or generic, we parse it with ParseFeedXML, which
+// looks at the document to decide its format. We resolve relative URLs
+// against the URL we fetched the feed from, after any redirects. See
+// ParseFeedXMLWithBase(). If the response has a Link header with hubs or a
+// self link, we add those to the feed.
+func FetchFeed(ctx context.Context, client *http.Client,
+	feedURL string) (*Feed, error) {
+	feed, _, err := fetchFeed(ctx, client, feedURL, CacheValidators{})
+	return feed, err
+}
+
+// fetchFeed is FetchFeed except it makes a conditional request if validators
+// has any. It returns the validators from the response. If the server says
+// the feed has not changed, it returns ErrNotModified.
+func fetchFeed(ctx context.Context, client *http.Client, feedURL string,
+	validators CacheValidators) (*Feed, CacheValidators, error) {
+	if client == nil {
+		client = defaultClient()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, CacheValidators{}, errors.Wrap(err, "error creating request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", userAgent)
+	// Setting this ourselves means the transport leaves decompressing to us.
+	// See decodeBody().
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, CacheValidators{}, errors.Wrap(err, "error making request")
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, validators, ErrNotModified
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, CacheValidators{}, &StatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+
+	bodyReader, err := decodeBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, CacheValidators{}, err
+	}
+	defer func() {
+		_ = bodyReader.Close()
+	}()
+
+	maxBytes := config.MaxFetchBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxFetchBytes
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(bodyReader, maxBytes+1))
+	if err != nil {
+		return nil, CacheValidators{},
+			errors.Wrap(err, "error reading response body")
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, CacheValidators{},
+			errors.Errorf("response body is larger than %d bytes", maxBytes)
+	}
+
+	// Resolve relative URLs against the URL we ended up at after any
+	// redirects.
+	baseURL := resp.Request.URL.String()
+
+	var feed *Feed
+	if isJSONContentType(resp.Header.Get("Content-Type")) {
+		feed, _, err = parseJSONFeed(body)
+		if err == nil {
+			resolveRelativeURLs(feed, baseURL)
+		}
+	} else {
+		feed, err = ParseFeedXMLWithBase(body, baseURL)
+	}
+	if err != nil {
+		return nil, CacheValidators{}, errors.Wrap(err, "error parsing feed")
+	}
+
+	applyLinkHeader(feed, resp.Header)
+
+	return feed, CacheValidators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// decodeBody wraps a response body to undo its Content-Encoding. We support
+// gzip and deflate. If there are several encodings, they were applied in
+// order, so we undo them in reverse.
+//
+// Closing the result closes the decompressors, but not body.
+func decodeBody(body io.Reader, contentEncoding string) (io.ReadCloser,
+	error) {
+	decoded := &decodedBody{Reader: body}
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		var r io.ReadCloser
+		var err error
+		switch encoding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(decoded.Reader)
+			err = errors.Wrap(err, "error decompressing gzip body")
+		case "deflate":
+			r, err = newDeflateReader(decoded.Reader)
+			err = errors.Wrap(err, "error decompressing deflate body")
+		default:
+			err = errors.Errorf("unsupported Content-Encoding: %s", encoding)
+		}
+		if err != nil {
+			_ = decoded.Close()
+			return nil, err
+		}
+		decoded.Reader = r
+		decoded.closers = append(decoded.closers, r)
+	}
+	return decoded, nil
+}
+
+// decodedBody is a response body with its Content-Encoding undone. closers
+// are the decompressors, in the order we opened them.
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes the decompressors, the last opened first.
+func (b *decodedBody) Close() error {
+	var err error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if closeErr := b.closers[i].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// newDeflateReader decompresses a deflate body. HTTP says this is zlib
+// format (RFC 1950), but some servers send raw deflate (RFC 1951) instead. We
+// look for a zlib header to tell which we have.
+func newDeflateReader(body io.Reader) (io.ReadCloser, error) {
+	r := bufio.NewReader(body)
+	header, err := r.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if len(header) == 2 && header[0]&0x0f == 8 &&
+		(uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(r)
+	}
+
+	return flate.NewReader(r), nil
+}
+
+// ErrNotModified is the error FeedFetcher returns if the feed has not changed
+// since the last time it fetched it.
+var ErrNotModified = errors.New("feed not modified")
+
+// CacheValidators are the values from a response we use to make a conditional
+// request for the same URL later.
+type CacheValidators struct {
+	// ETag is the response's ETag header.
+	ETag string `json:"etag,omitempty"`
+
+	// LastModified is the response's Last-Modified header.
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// FeedFetcher fetches feeds with conditional requests. This saves bandwidth
+// when polling feeds, and servers are less likely to rate limit you.
+//
+// It remembers each URL's ETag and Last-Modified headers, and sends them back
+// (as If-None-Match and If-Modified-Since) the next time it fetches the URL.
+// If the server says the feed hasn't changed, Fetch returns ErrNotModified.
+//
+// To remember them across runs, save State() and restore it with SetState().
+//
+// The zero value is ready to use. It is safe to use from multiple goroutines.
+type FeedFetcher struct {
+	// Client is the client to make requests with. If it is nil we use the same
+	// client as FetchFeed.
+	Client *http.Client
+
+	mutex sync.Mutex
+	state map[string]CacheValidators
+}
+
+// Fetch fetches and parses a feed. It is like FetchFeed except it makes a
+// conditional request if it fetched the URL before. If the feed has not
+// changed since then, it returns ErrNotModified.
+func (f *FeedFetcher) Fetch(ctx context.Context, feedURL string) (*Feed,
+	error) {
+	f.mutex.Lock()
+	validators := f.state[feedURL]
+	f.mutex.Unlock()
+
+	feed, validators, err := fetchFeed(ctx, f.Client, feedURL, validators)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.state == nil {
+		f.state = map[string]CacheValidators{}
+	}
+	if validators.ETag == "" && validators.LastModified == "" {
+		delete(f.state, feedURL)
+	} else {
+		f.state[feedURL] = validators
+	}
+
+	return feed, nil
+}
+
+// State returns a copy of the validators the fetcher has for each URL. You can
+// save this, such as by encoding it as JSON, and restore it with SetState().
+func (f *FeedFetcher) State() map[string]CacheValidators {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	state := make(map[string]CacheValidators, len(f.state))
+	for feedURL, validators := range f.state {
+		state[feedURL] = validators
+	}
+	return state
+}
+
+// SetState replaces the validators the fetcher has with ones from State().
+func (f *FeedFetcher) SetState(state map[string]CacheValidators) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.state = make(map[string]CacheValidators, len(state))
+	for feedURL, validators := range state {
+		f.state[feedURL] = validators
+	}
+}
+
+// isJSONContentType decides whether a Content-Type header value is one for
+// JSON Feed.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/feed+json" ||
+		mediaType == "application/json"
+}
+
+// applyLinkHeader adds hubs and the self link from HTTP Link headers to the
+// feed. We keep a self link the feed has, and don't add a hub twice.
+func applyLinkHeader(feed *Feed, header http.Header) {
+	for _, link := range ParseLinkHeader(strings.Join(header["Link"], ",")) {
+		if link.HasRel("self") && feed.Self == "" {
+			feed.Self = link.URL
+		}
+		if link.HasRel("hub") && !containsString(feed.Hubs, link.URL) {
+			feed.Hubs = append(feed.Hubs, link.URL)
+		}
+	}
+}
+
+// containsString reports whether a slice contains a string.
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchFullFeed fetches a paged feed (RFC 5005), such as an archive, and
+// merges its pages into one feed.
+//
+// We fetch the first page, then follow each page's next link (NextPageURL)
+// until there is none, we come back to a page we already fetched, or we have
+// fetched MaxPages pages. We merge the items with Update(), so they are
+// deduplicated and sorted newest first. The feed's metadata is the first
+// page's.
+//
+// We check ctx between pages, so cancelling it stops us after the current
+// page.
+func FetchFullFeed(ctx context.Context, feedURL string) (*Feed, error) {
+	feed, err := FetchFeed(ctx, nil, feedURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching first page")
+	}
+
+	visited := map[string]bool{feedURL: true}
+	page := feed
+	pageURL := feedURL
+	for pages := 1; pages < config.MaxPages; pages++ {
+		next := resolveURL(pageURL, page.NextPageURL)
+		if next == "" || visited[next] {
+			break
+		}
+		visited[next] = true
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		page, err = FetchFeed(ctx, nil, next)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error fetching page %s", next)
+		}
+		pageURL = next
+
+		feed.Update(page)
+	}
+
+	feed.NextPageURL = ""
+
+	return feed, nil
+}
+
+// resolveURL resolves a possibly relative URL against a base URL. It returns
+// blank if ref is blank or either doesn't parse.
+func resolveURL(base, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+
+	return baseURL.ResolveReference(refURL).String()
+}
@@ -0,0 +1,70 @@
+package rss
+
+import (
+	"html/template"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// htmlTemplate is the markup WriteHTML writes. html/template escapes the
+// values, and replaces unsafe URLs (such as javascript: ones).
+var htmlTemplate = template.Must(template.New("feed").Parse(
+	`<ul class="feed">
+{{- range .}}
+  <li class="feed-item">
+    {{- if .Link}}<a class="feed-item-link" href="{{.Link}}">{{.Title}}</a>
+    {{- else}}<span class="feed-item-title">{{.Title}}</span>{{end}}
+    {{- if .Date}} <time class="feed-item-date" datetime="{{.DateTime}}">{{.Date}}</time>{{end}}
+    {{- if .Description}}
+    <p class="feed-item-description">{{.Description}}</p>
+    {{- end}}
+  </li>
+{{- end}}
+</ul>
+`))
+
+// htmlItem is what htmlTemplate needs to know about an item.
+type htmlItem struct {
+	Title       string
+	Link        string
+	Date        string
+	DateTime    string
+	Description string
+}
+
+// WriteHTML writes the feed's items as an HTML fragment. This is useful for
+// showing a feed on a web page.
+//
+// The fragment is a <ul> with an <li> per item. Each holds a link to the item,
+// its date if it has one, and its description as plain text. The elements
+// have classes (feed, feed-item, feed-item-link, and so on) so you can style
+// them.
+//
+// Everything is escaped. We convert descriptions to plain text rather than
+// trying to sanitize their HTML.
+func (f *Feed) WriteHTML(w io.Writer) error {
+	var items []htmlItem
+	for _, item := range f.Items {
+		h := htmlItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			Description: plaintext(item.Description),
+		}
+		if h.Title == "" {
+			h.Title = item.Link
+		}
+		if !item.PubDate.IsZero() {
+			h.Date = item.PubDate.Format("2006-01-02")
+			h.DateTime = item.PubDate.Format(time.RFC3339)
+		}
+		items = append(items, h)
+	}
+
+	if err := htmlTemplate.Execute(w, items); err != nil {
+		return errors.Wrap(err, "error writing HTML")
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package rss
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// tagDateRE matches the date part of a tag URI: YYYY, YYYY-MM, or YYYY-MM-DD.
+var tagDateRE = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2})?)?$`)
+
+// ParsedGUID splits the item's GUID into its parts if it is a tag URI (RFC
+// 4151), such as tag:example.com,2024:post-1. In that example, authority is
+// example.com, date is 2024, and specific is post-1. specific includes any
+// #fragment.
+//
+// ok is false if the GUID is not a tag URI.
+func (i *Item) ParsedGUID() (authority, date, specific string, ok bool) {
+	guid := strings.TrimSpace(i.GUID)
+	if len(guid) < 4 || !strings.EqualFold(guid[:4], "tag:") {
+		return "", "", "", false
+	}
+
+	// The tagging entity (authority,date) ends at the first colon. The specific
+	// part may contain colons.
+	rest := guid[4:]
+	colon := strings.Index(rest, ":")
+	if colon == -1 {
+		return "", "", "", false
+	}
+	entity, specific := rest[:colon], rest[colon+1:]
+
+	// The authority may be an email address but it won't contain a comma.
+	comma := strings.LastIndex(entity, ",")
+	if comma == -1 {
+		return "", "", "", false
+	}
+	authority, date = entity[:comma], entity[comma+1:]
+
+	if authority == "" || !tagDateRE.MatchString(date) {
+		return "", "", "", false
+	}
+
+	return authority, date, specific, true
+}
+
+// defaultWordsPerMinute is the reading speed ReadingTime() uses if it isn't
+// given one.
+const defaultWordsPerMinute = 200
+
+// ReadingTime estimates how long the item takes to read, for showing "N min
+// read".
+//
+// We count the words in the item's content as plain text, or its description
+// if it has no content, and divide by wordsPerMinute. If wordsPerMinute is
+// not positive we use 200. We round to the nearest minute, but an item with
+// any words takes at least a minute. An item with no words takes zero.
+func (i *Item) ReadingTime(wordsPerMinute int) time.Duration {
+	text := i.Content
+	if strings.TrimSpace(text) == "" {
+		text = i.Description
+	}
+
+	words := len(strings.Fields(plaintext(text)))
+	if words == 0 {
+		return 0
+	}
+
+	if wordsPerMinute <= 0 {
+		wordsPerMinute = defaultWordsPerMinute
+	}
+
+	minutes := (words + wordsPerMinute/2) / wordsPerMinute
+	if minutes < 1 {
+		minutes = 1
+	}
+	return time.Duration(minutes) * time.Minute
+}
@@ -0,0 +1,163 @@
+package rss
+
+import (
+	"encoding/json"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// jsonFeedJSON describes a JSON Feed. We use it for parsing. See
+// https://jsonfeed.org/version/1.1
+type jsonFeedJSON struct {
+	// Version is the URL of the version of the format. It is required.
+	Version string `json:"version"`
+
+	Title       string `json:"title"`
+	HomePageURL string `json:"home_page_url"`
+	FeedURL     string `json:"feed_url"`
+	Description string `json:"description"`
+
+	// Version 1.1 has authors. Version 1 has author.
+	Authors []jsonFeedAuthorJSON `json:"authors"`
+	Author  *jsonFeedAuthorJSON  `json:"author"`
+
+	Hubs []jsonFeedHubJSON `json:"hubs"`
+
+	// We decode each item separately so we have its JSON for OnItemError.
+	Items []json.RawMessage `json:"items"`
+}
+
+// jsonFeedAuthorJSON describes an author object.
+type jsonFeedAuthorJSON struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// jsonFeedHubJSON describes a hub object.
+type jsonFeedHubJSON struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// jsonFeedItemJSON describes an item.
+type jsonFeedItemJSON struct {
+	// ID is required. It should be a string, but some feeds use numbers.
+	ID json.RawMessage `json:"id"`
+
+	URL           string   `json:"url"`
+	Title         string   `json:"title"`
+	ContentHTML   string   `json:"content_html"`
+	ContentText   string   `json:"content_text"`
+	Summary       string   `json:"summary"`
+	DatePublished string   `json:"date_published"`
+	DateModified  string   `json:"date_modified"`
+	Tags          []string `json:"tags"`
+
+	Authors []jsonFeedAuthorJSON `json:"authors"`
+	Author  *jsonFeedAuthorJSON  `json:"author"`
+}
+
+// parseAsJSONFeed attempts to parse the buffer as a JSON Feed.
+//
+// The item's Description is its content_html. If it has none, we use its
+// content_text (escaped), and then its summary.
+func parseAsJSONFeed(data []byte) (*Feed, error) {
+	var jsonFeed jsonFeedJSON
+	if err := json.Unmarshal(data, &jsonFeed); err != nil {
+		return nil, errors.Wrap(err, "JSON decode error")
+	}
+
+	if !strings.HasPrefix(jsonFeed.Version, "https://jsonfeed.org/version/") {
+		return nil, errors.New("version is not JSON Feed")
+	}
+
+	feed := &Feed{
+		Title:       jsonFeed.Title,
+		Link:        jsonFeed.HomePageURL,
+		Self:        jsonFeed.FeedURL,
+		Description: jsonFeed.Description,
+		Type:        "JSON",
+		FeedType:    FeedJSON,
+		Author:      jsonFeedAuthor(jsonFeed.Authors, jsonFeed.Author),
+	}
+
+	for _, hub := range jsonFeed.Hubs {
+		if hub.URL != "" {
+			feed.Hubs = append(feed.Hubs, hub.URL)
+		}
+	}
+
+	if config.Verbose {
+		log.Printf("Parsed channel as JSON Feed [%s]", feed.Title)
+	}
+
+	for _, raw := range jsonFeed.Items {
+		var item jsonFeedItemJSON
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return nil, errors.Wrap(err, "JSON decode error")
+		}
+
+		description := item.ContentHTML
+		if description == "" && item.ContentText != "" {
+			description = html.EscapeString(item.ContentText)
+		}
+		if description == "" {
+			description = item.Summary
+		}
+
+		date := item.DatePublished
+		if date == "" {
+			date = item.DateModified
+		}
+
+		feedItem := Item{
+			Title:       collapseSpace(item.Title),
+			Link:        item.URL,
+			Description: description,
+			PubDate:     parseTime(date),
+			GUID:        jsonFeedID(item.ID),
+			Author:      jsonFeedAuthor(item.Authors, item.Author),
+			PubDateRaw:  date,
+		}
+		for _, tag := range item.Tags {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				feedItem.Categories = append(feedItem.Categories,
+					Category{Name: tag})
+			}
+		}
+		reportItemErrors(string(raw), feedItem)
+		feed.Items = append(feed.Items, feedItem)
+	}
+
+	finishFeed(feed)
+
+	return feed, nil
+}
+
+// jsonFeedAuthor returns the name of the first author. Version 1.1 has
+// authors and version 1 has author, so we look at both.
+func jsonFeedAuthor(authors []jsonFeedAuthorJSON,
+	author *jsonFeedAuthorJSON) string {
+	for _, a := range authors {
+		if name := strings.TrimSpace(a.Name); name != "" {
+			return name
+		}
+	}
+	if author != nil {
+		return strings.TrimSpace(author.Name)
+	}
+	return ""
+}
+
+// jsonFeedID turns an item's id into a string. It should be a string already,
+// but if it's something else, such as a number, we use its JSON text.
+func jsonFeedID(id json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(id, &s); err == nil {
+		return s
+	}
+	return string(id)
+}
@@ -1,42 +1,746 @@
 // Package rss provides helper function for interacting with RSS, RDF, and Atom
 // feeds. Primarily this surrounds building and reading/parsing.
+//
+// When parsing, we match namespaced elements by their namespace URI. The
+// prefix a feed uses for a namespace doesn't matter, so <atom10:link> and
+// <atom:link> are the same if both prefixes are bound to the Atom namespace.
 package rss
 
-import "time"
+import (
+	"encoding/xml"
+	"time"
+)
 
 // Feed contains information about a feed.
 type Feed struct {
-	Title       string
-	Link        string
+	Title string
+	Link  string
+
+	// Self is the URL of the feed itself, from <atom:link rel="self"> (or
+	// <link rel="self"> in Atom). When writing a feed, we include it the same
+	// way if it is set.
+	Self string
+
+	// NextPageURL is the URL of the next page of the feed, from
+	// <atom:link rel="next"> (or <link rel="next"> in Atom). Paged feeds and
+	// archives (RFC 5005) have it. See FetchFullFeed.
+	NextPageURL string
+
+	// Hubs are the WebSub hubs the feed advertises with <atom:link rel="hub">
+	// (or <link rel="hub"> in Atom).
+	Hubs []string
+
 	Description string
 	PubDate     time.Time
-	Items       []Item
-	Type        string
+
+	// LastBuildDate is when the feed's content last changed, from RSS's
+	// <lastBuildDate>. It is zero if the feed doesn't say. When writing RSS we
+	// use PubDate if it is zero.
+	LastBuildDate time.Time
+
+	Items []Item
+
+	// Type is the format we parsed the feed from: RSS, RDF, Atom, JSON (for
+	// JSON Feed), or HTML (if we scraped it).
+	//
+	// Deprecated: Use FeedType. It is easy to mistype a string when comparing.
+	Type string
+
+	// FeedType is the format we parsed the feed from. It is FeedUnknown if we
+	// didn't parse the feed, such as if you built it yourself.
+	FeedType FeedType
+
+	// ID is the feed's unique identifier. Atom feeds have one (<id>).
+	ID string
+
+	// Categories the feed as a whole belongs to. For RSS these are from
+	// <category>, for RDF <dc:subject>, and for Atom <category>.
+	Categories []Category
+
+	// Author is who is responsible for the feed. For RSS this is from
+	// <managingEditor>, and for Atom the feed's <author> name.
+	Author string
+
+	// Generator is the software that made the feed, from <generator>. It is
+	// nil if the feed doesn't say.
+	Generator *Generator
+
+	// Image is the feed's artwork, from <image>. For Atom it is from <logo>,
+	// or <icon> if there is no logo. It is nil if the feed doesn't have one.
+	Image *Image
+
+	// Language is the language the feed is written in, such as en-us. For RSS
+	// this is from <language> (or <dc:language>), for RDF <dc:language>, and
+	// for Atom the feed's xml:lang attribute. It is blank if the feed doesn't
+	// say.
+	Language string
+
+	// Copyright is the feed's copyright notice. For RSS this is from
+	// <copyright> (or <dc:rights>), for RDF <dc:rights>, and for Atom <rights>.
+	Copyright string
+
+	// UpdatePeriod and UpdateFrequency come from the syndication module
+	// (<sy:updatePeriod> and <sy:updateFrequency>). They say the feed updates
+	// UpdateFrequency times per UpdatePeriod. UpdatePeriod is one of hourly,
+	// daily, weekly, monthly, or yearly. They are blank/zero if the feed
+	// doesn't say. See SuggestedInterval().
+	UpdatePeriod    string
+	UpdateFrequency int
+
+	// TTL is how long the feed may be cached before fetching it again. For RSS
+	// this is from <ttl> (a number of minutes). If the feed has no <ttl>, we
+	// use the syndication module's elements. See SuggestedInterval(). It is
+	// zero if the feed says neither. See also SuggestedRefreshInterval().
+	TTL time.Duration
+
+	// Warnings describes problems we found and worked around while parsing the
+	// feed.
+	Warnings []string
+
+	// ITunes holds podcast information from the iTunes namespace. It is nil if
+	// the feed has none.
+	ITunes *ITunesFeed
+
+	// Extensions holds channel elements we don't otherwise parse. It is only
+	// populated if the CaptureExtensions setting is on.
+	Extensions Extensions
+}
+
+// ITunesFeed contains channel level podcast information from the iTunes
+// namespace (http://www.itunes.com/dtds/podcast-1.0.dtd).
+type ITunesFeed struct {
+	// OwnerName and OwnerEmail come from <itunes:owner>. Apple Podcasts requires
+	// them.
+	OwnerName  string
+	OwnerEmail string
+
+	// Type is either episodic or serial.
+	Type string
+
+	// ImageURL is the podcast's artwork, from <itunes:image href>.
+	ImageURL string
+
+	// Categories are from <itunes:category>. These are from Apple's list of
+	// categories.
+	Categories []ITunesCategory
+}
+
+// ITunesCategory is an <itunes:category>. A category may have subcategories,
+// such as Technology containing Podcasting.
+type ITunesCategory struct {
+	Name          string
+	Subcategories []string
+}
+
+// ITunesItem contains item level podcast information from the iTunes
+// namespace.
+type ITunesItem struct {
+	// Author is from <itunes:author>.
+	Author string
+
+	// Duration is the episode's length, from <itunes:duration>. It is zero if
+	// the item doesn't say or we can't parse it.
+	Duration time.Duration
+
+	// Episode and Season are from <itunes:episode> and <itunes:season>. They
+	// are zero if the item doesn't say.
+	Episode int
+	Season  int
+
+	// Explicit is true if <itunes:explicit> is true (or yes or explicit). See
+	// also Item.AdultContent.
+	Explicit bool
+}
+
+// Generator describes the software that made a feed. RSS only has a name.
+// Atom may also have a URI and version.
+type Generator struct {
+	Name    string
+	URI     string
+	Version string
+}
+
+// Image is a feed's artwork.
+type Image struct {
+	URL string
+
+	// Title describes the image. In RSS it is also the image's alt text.
+	Title string
+
+	// Link is the URL the image links to, usually the feed's site.
+	Link string
+
+	// Description is text for the link's title attribute. It is optional.
+	Description string
+
+	// Width and Height are the image's size in pixels. They are zero if the
+	// feed doesn't say.
+	Width  int
+	Height int
 }
 
 // Item contains information about an item/entry in a feed.
 type Item struct {
-	Title       string
-	Link        string
+	// Title is plain text. Feeds may put markup in titles: RSS and RDF titles
+	// are often HTML (escaped, or in CDATA), and Atom titles may have type html
+	// or xhtml. We remove tags and decode entities, so <b>Hi</b> becomes Hi
+	// whichever format it came from. An Atom title with type text is already
+	// plain text and we keep it as it is. In every format we remove
+	// surrounding whitespace and collapse runs of whitespace to one space.
+	Title string
+
+	Link string
+
+	// Description is the item's description, usually HTML. Feeds either escape
+	// HTML as entities (&lt;p&gt;) or put it raw in a CDATA section. Either way
+	// Description holds the HTML itself (<p>), so you don't need to know which
+	// the feed used. We only decode one level: if a feed escapes its HTML twice
+	// (&amp;lt;p&amp;gt;), the result is HTML that shows the tags as text, as
+	// the feed's author wrote it. Content and Summary are the same.
 	Description string
-	PubDate     time.Time
-	GUID        string
+
+	// ContentType says what Description holds for Atom entries. It is the type
+	// attribute of the <content> (or <summary>) we took Description from:
+	// text if it is plain text, or html or xhtml if it is markup. For xhtml,
+	// Description is the markup inside the wrapping <div>. It may also be a
+	// MIME type such as text/plain. It is blank for other formats, whose
+	// descriptions are HTML.
+	ContentType string
+
+	PubDate time.Time
+	GUID    string
+
+	// GUIDIsPermaLink is the RSS <guid isPermaLink> attribute. It says whether
+	// the GUID is a URL to the item. It is nil if the feed doesn't say, in
+	// which case the RSS specification says it is.
+	GUIDIsPermaLink *bool
+
+	// Content is the item's full content, from <content:encoded>. Feeds that
+	// have it often put only a summary in Description.
+	Content string
+
+	// Summary is a short version of the item, from Atom's <summary> (or
+	// <atom:summary> in RSS). If the item has no other description, we use it
+	// as Description too.
+	Summary string
+
+	// Author is who wrote the item. For RSS this is from <author>, which is
+	// often an email address such as "john@example.com (John)", or
+	// <dc:creator> if there is no <author>. For RDF it is from <dc:creator>,
+	// and for Atom it is the entry's <author> name. If the InheritAuthor
+	// setting is on, items without an author take the feed's.
+	Author string
+
+	// Copyright is the item's copyright notice, from <dc:rights> (or Atom's
+	// <rights>). Items without one take the feed's Copyright. It is blank if
+	// neither declares one.
+	Copyright string
+
+	// Categories the item is tagged with. For RSS these are from <category>,
+	// for RDF <dc:subject>, and for Atom <category term="...">.
+	Categories []Category
+
+	// PubDateRaw is the date text we parsed PubDate from.
+	//
+	// For RSS items we use <pubDate> if present, otherwise <dc:date>. If an item
+	// has both and they disagree by more than an hour, we add a warning to the
+	// feed's Warnings.
+	PubDateRaw string
+
+	// Rating is the item's content rating from <media:rating>, such as adult
+	// or nonadult (urn:simple), or r (urn:mpaa). We lowercase it. It is blank
+	// if the item has none.
+	Rating string
+
+	// AdultContent is true if the item says it is for adults. That is, it has a
+	// urn:simple <media:rating> of adult, or <itunes:explicit> is true.
+	AdultContent bool
+
+	// CommentCount is the number of comments on the item. We take it from the
+	// first of these we find:
+	//
+	// 1. <slash:comments>
+	// 2. <thr:total>
+	//
+	// WordPress feeds also have <wfw:commentRss>, but that is the URL of a
+	// comment feed rather than a count, so we don't use it here. See
+	// CommentsFeedURL.
+	//
+	// See Slash and Thread for the elements themselves.
+	CommentCount int
+
+	// CommentsFeedURL is the URL of a feed of the item's comments, from
+	// <wfw:commentRss>. WordPress feeds have it.
+	CommentsFeedURL string
+
+	// Slash holds information from the Slash namespace. It is nil if the item
+	// has none.
+	Slash *Slash
+
+	// Thread holds information from the Atom threading namespace. It is nil if
+	// the item has none.
+	Thread *Thread
+
+	// Source describes the feed the item originally came from, if it was
+	// republished from another feed. It is nil if the item doesn't say.
+	Source *Source
+
+	// Enclosures are media files attached to the item, such as a podcast
+	// episode's audio. For RSS these are from <enclosure>, and for Atom
+	// <link rel="enclosure">.
+	Enclosures []Enclosure
+
+	// Media are the item's standalone <media:content> elements from Media RSS.
+	Media []MediaContent
+
+	// MediaGroups are the item's <media:group> elements from Media RSS. Each
+	// group holds renditions of the same media, such as a video at different
+	// bitrates, so you can pick the one best suited to the viewer.
+	MediaGroups [][]MediaContent
+
+	// ImageURL is the item's artwork, from the item's <itunes:image href>. If
+	// the item doesn't have one, this is the channel's (ITunes.ImageURL).
+	ImageURL string
+
+	// ITunes holds podcast information from the iTunes namespace. It is nil if
+	// the item has none.
+	ITunes *ITunesItem
+
+	// Podcast holds information from the Podcasting 2.0 namespace. It is nil
+	// if the item has none.
+	Podcast *PodcastItem
+
+	// Extensions holds item elements we don't otherwise parse. It is only
+	// populated if the CaptureExtensions setting is on.
+	Extensions Extensions
+}
+
+// Category is a category or tag. In RSS this is <category>.
+type Category struct {
+	// Name is the category. For Atom this is the term attribute.
+	Name string
+
+	// Domain identifies the taxonomy the category is from. It is optional. For
+	// Atom this is the scheme attribute.
+	Domain string
+}
+
+// Enclosure is a media file attached to an item.
+type Enclosure struct {
+	URL string
+
+	// Length is the size of the file in bytes. It is zero if the feed doesn't
+	// say.
+	Length int64
+
+	// Type is the file's MIME type, such as audio/mpeg.
+	Type string
+}
+
+// MediaContent is a media object from Media RSS's <media:content>.
+type MediaContent struct {
+	URL string
+
+	// Type is the object's MIME type, such as video/mp4.
+	Type string
+
+	// Medium is the kind of object: image, audio, video, document or
+	// executable.
+	Medium string
+
+	// FileSize is in bytes, and Bitrate in kilobits per second. These and the
+	// other numbers are zero if the feed doesn't say.
+	FileSize int64
+	Bitrate  int
+	Width    int
+	Height   int
+	Duration time.Duration
+
+	// IsDefault is whether this is the default object in its group.
+	IsDefault bool
+}
+
+// Source describes the feed an item came from. It is from Atom's <source>.
+type Source struct {
+	Title string
+	ID    string
+	Link  string
+}
+
+// Slash contains item information from the Slash namespace
+// (http://purl.org/rss/1.0/modules/slash/). Slashdot uses it.
+type Slash struct {
+	Section    string
+	Department string
+	Comments   int
+	HitParade  string
+}
+
+// Thread contains item information from the Atom threading extensions
+// (RFC 4685).
+type Thread struct {
+	// Total is the number of replies from <thr:total>.
+	Total int
+}
+
+// PodcastItem contains item information from the Podcasting 2.0 namespace
+// (https://podcastindex.org/namespace/1.0).
+type PodcastItem struct {
+	// TranscriptURL and TranscriptType come from <podcast:transcript>. The type
+	// is a MIME type such as text/vtt. If there is more than one transcript we
+	// take the first.
+	TranscriptURL  string
+	TranscriptType string
+
+	// ChaptersURL and ChaptersType come from <podcast:chapters>.
+	ChaptersURL  string
+	ChaptersType string
+}
+
+// Extension is an element we don't otherwise parse, such as one from a
+// namespace we don't know about.
+type Extension struct {
+	// Space is the namespace URI of the element. It is blank if the element is
+	// not in a namespace.
+	Space string
+
+	// Name is the local name of the element.
+	Name string
+
+	// Attrs holds the element's attributes, excluding namespace declarations.
+	Attrs []xml.Attr
+
+	// Value is the element's character data. Character data inside any child
+	// elements is not included.
+	Value string
+}
+
+// Extensions holds unknown elements in the order they appear in the document.
+type Extensions []Extension
+
+// Map groups extension values by element name. This is convenient if you
+// don't care about the order of different elements. Values of repeated
+// elements are still in document order.
+func (e Extensions) Map() map[xml.Name][]string {
+	m := map[xml.Name][]string{}
+	for _, ext := range e {
+		name := xml.Name{Space: ext.Space, Local: ext.Name}
+		m[name] = append(m[name], ext.Value)
+	}
+	return m
+}
+
+// FeedType is a format we parse feeds from.
+type FeedType int
+
+// The formats we parse feeds from.
+const (
+	FeedUnknown FeedType = iota
+	FeedRSS
+	FeedRDF
+	FeedAtom
+	FeedJSON
+	FeedHTML
+)
+
+// String returns the name of the format. For formats other than FeedUnknown,
+// this is the same as Feed.Type.
+func (t FeedType) String() string {
+	switch t {
+	case FeedRSS:
+		return "RSS"
+	case FeedRDF:
+		return "RDF"
+	case FeedAtom:
+		return "Atom"
+	case FeedJSON:
+		return "JSON"
+	case FeedHTML:
+		return "HTML"
+	}
+	return "Unknown"
 }
 
 // Config controls package wide settings.
 type Config struct {
 	// Control whether we have verbose output (or not).
 	Verbose bool
+
+	// Control whether we record elements we don't otherwise parse into
+	// Extensions.
+	CaptureExtensions bool
+
+	// Control whether we fall back to scraping documents that are not feeds as
+	// HTML. We take the title from <title> or <h1> and make an item for each
+	// <a href>. Such feeds have Type "HTML" and a warning in Warnings.
+	Scrape bool
+
+	// Control whether we drop items that have no title, description, or
+	// content. If we drop any, we say how many in Warnings.
+	SkipEmptyItems bool
+
+	// MaxContentBytes limits the size of each item's Description and Content.
+	// If one is larger, we truncate it (at a UTF-8 character boundary) and add
+	// a warning to Warnings. Zero means no limit.
+	//
+	// Note this limits what we keep, not what we read. You should limit the
+	// size of the document as well.
+	MaxContentBytes int
+
+	// Control whether we drop (set to blank) a Description or Content larger
+	// than MaxContentBytes rather than truncating it.
+	DropOversizedContent bool
+
+	// Control whether ValidateFeedURL accepts URLs with private or loopback
+	// hosts. You might want this if you fetch feeds from an intranet.
+	AllowPrivateHosts bool
+
+	// Control whether FetchFeed (and FeedFetcher and FetchFullFeed) refuse to
+	// connect to private addresses when we use our own client. We check the
+	// address after resolving the host, so this works even if a name resolves
+	// to a private address. See DenyPrivateAddresses. If you pass a client,
+	// this has no effect.
+	CheckDialedAddress bool
+
+	// DefaultGenerator is the generator name we write in feeds that don't have
+	// a Generator. If it is blank we write no generator.
+	DefaultGenerator string
+
+	// Control whether items without an author take the feed's Author.
+	InheritAuthor bool
+
+	// GUIDStrategy controls what we write as each item's <guid> when writing
+	// RSS. See GUIDStrategy.
+	GUIDStrategy GUIDStrategy
+
+	// Control whether we sort elements whose order doesn't matter when writing
+	// feeds. Currently this is categories, which we sort by domain and then
+	// name. Our output is the same for the same input either way, but with
+	// this on, feeds that differ only in category order give the same output.
+	CanonicalOrder bool
+
+	// Control whether we write the newest item's date as the <lastBuildDate>
+	// when writing RSS. When this is off, or no item has a date, we write the
+	// feed's LastBuildDate, or its PubDate if it has none.
+	AutoLastBuildDate bool
+
+	// Control whether we refuse to write RSS that doesn't meet all of the RSS
+	// 2.0.1 requirements. When this is on, WriteFeedXML returns an error
+	// without writing anything if:
+	//
+	// - The channel is missing its title, link, or description.
+	// - An item has neither a title nor a description.
+	// - An item would have no <guid>. This can happen with the GUIDOmit
+	//   strategy, or with the GUIDLink strategy if the item has no link.
+	//
+	// We also write dates in GMT, as in "Mon, 02 Jan 2006 15:04:05 GMT",
+	// rather than with a numeric offset. This is the form the RSS specification
+	// uses in its examples, and the one parsers most reliably accept.
+	//
+	// We always escape text correctly, so there is nothing extra to enforce
+	// there.
+	StrictRFC bool
+
+	// MaxPages is the most pages FetchFullFeed fetches.
+	MaxPages int
+
+	// MaxFetchBytes is the most we read of a response body when fetching a
+	// feed. If the body is compressed, this is its size after decompressing,
+	// so a small compressed body can't expand to use all of our memory. If it
+	// is not positive we use the default, 10 MiB.
+	MaxFetchBytes int64
+
+	// OnItemError, if set, is called for each item we had trouble with while
+	// parsing, such as one with a date we can't parse, or with no title or
+	// description. raw is the item's XML (the contents of the item element),
+	// or its JSON for JSON Feed. We still include the item in the feed.
+	//
+	// If you parse feeds in several goroutines at once, such as with
+	// ParseFeedsFromDir(), it may be called concurrently.
+	OnItemError func(raw string, err error)
+
+	// AllowedSchemes are the URL schemes we accept in item links and enclosure
+	// URLs. If an item's link has another scheme, such as javascript: or
+	// data:, we blank it. We drop enclosures with other schemes. We record
+	// what we removed in Warnings. Relative URLs have no scheme and we always
+	// keep them.
+	//
+	// Comparison is case insensitive. If this is empty we allow all schemes.
+	// The default is http, https, and mailto.
+	AllowedSchemes []string
+
+	// Control whether we sanitize HTML in descriptions when parsing. This is
+	// the feed's Description, and each item's Description, Content, and
+	// Summary. Turn it on if you show parsed feeds in a web page.
+	//
+	// We keep only an allowlist of elements (a, p, br, img, strong, em, ul,
+	// ol, li, blockquote, and code) and attributes (href and title on links,
+	// and src, alt, title, width, and height on images). Other elements are
+	// removed but we keep their text, except for <script> and <style>, which
+	// we remove entirely. Links and images must be http, https, or relative
+	// (links may also be mailto). We also remove 1x1 images as they are
+	// usually tracking pixels.
+	SanitizeHTML bool
+
+	// Control whether we normalize percent-encoding in identifiers when
+	// parsing. Some feeds encode the same GUID differently from one fetch to
+	// the next, which makes the item look new.
+	//
+	// This applies to the feed's ID, Link, and Self, and each item's GUID and
+	// Link. We decode escapes of unreserved characters (letters, digits, '-',
+	// '.', '_', and '~'), so "%7Euser" becomes "~user". We uppercase the hex
+	// digits of other escapes, so "%2f" becomes "%2F". We never decode escapes
+	// of reserved characters such as '/' as that can change what the
+	// identifier means. See RFC 3986 section 6.2.2.
+	NormalizePercentEncoding bool
+
+	// Control whether we try harder to make sense of malformed feeds. When we
+	// do, we say what we worked around in Warnings.
+	//
+	// Currently this means:
+	//
+	// If a date has a bogus timezone such as "+0000 UTC" or "GMT+00:00", we
+	// drop the timezone and try again. (Even without this setting, an item
+	// with a date we can't parse is kept with a zero PubDate.)
+	//
+	// If the document isn't well-formed XML, such as if an item has a
+	// mismatched tag or an undefined entity, we skip the items we can't decode
+	// and keep the rest. We say which we skipped in Warnings. The feed's
+	// metadata must still be well-formed.
+	Lenient bool
 }
 
+// GUIDStrategy says what to write as an item's <guid> when writing RSS.
+type GUIDStrategy int
+
+const (
+	// GUIDLink writes the item's GUID, or its link if it has no GUID. This is
+	// the default.
+	GUIDLink GUIDStrategy = iota
+
+	// GUIDOmit writes the item's GUID if it has one, and otherwise writes no
+	// <guid> at all. Use this if your items have no stable identifier.
+	GUIDOmit
+)
+
 // Use a global default set of settings.
 //
 // See package log for a similar approach (global default settings).
 var config = Config{
-	Verbose: false,
+	Verbose:           false,
+	CaptureExtensions: false,
+	Scrape:            false,
+	SkipEmptyItems:    false,
+	MaxContentBytes:   0,
+	AllowPrivateHosts: false,
+	MaxPages:          50,
+	MaxFetchBytes:     defaultMaxFetchBytes,
+	AllowedSchemes:    []string{"http", "https", "mailto"},
 }
 
 // SetVerbose controls the package setting 'Verbose'.
 func SetVerbose(verbose bool) {
 	config.Verbose = verbose
 }
+
+// SetCaptureExtensions controls the package setting 'CaptureExtensions'.
+func SetCaptureExtensions(capture bool) {
+	config.CaptureExtensions = capture
+}
+
+// SetScrape controls the package setting 'Scrape'.
+func SetScrape(scrape bool) {
+	config.Scrape = scrape
+}
+
+// SetSkipEmptyItems controls the package setting 'SkipEmptyItems'.
+func SetSkipEmptyItems(skip bool) {
+	config.SkipEmptyItems = skip
+}
+
+// SetMaxContentBytes controls the package setting 'MaxContentBytes'.
+func SetMaxContentBytes(max int) {
+	config.MaxContentBytes = max
+}
+
+// SetDropOversizedContent controls the package setting
+// 'DropOversizedContent'.
+func SetDropOversizedContent(drop bool) {
+	config.DropOversizedContent = drop
+}
+
+// SetAllowPrivateHosts controls the package setting 'AllowPrivateHosts'.
+func SetAllowPrivateHosts(allow bool) {
+	config.AllowPrivateHosts = allow
+}
+
+// SetCheckDialedAddress controls the package setting 'CheckDialedAddress'.
+func SetCheckDialedAddress(check bool) {
+	config.CheckDialedAddress = check
+}
+
+// SetDefaultGenerator controls the package setting 'DefaultGenerator'.
+func SetDefaultGenerator(name string) {
+	config.DefaultGenerator = name
+}
+
+// SetInheritAuthor controls the package setting 'InheritAuthor'.
+func SetInheritAuthor(inherit bool) {
+	config.InheritAuthor = inherit
+}
+
+// SetLenient controls the package setting 'Lenient'.
+func SetLenient(lenient bool) {
+	config.Lenient = lenient
+}
+
+// SetGUIDStrategy controls the package setting 'GUIDStrategy'.
+func SetGUIDStrategy(strategy GUIDStrategy) {
+	config.GUIDStrategy = strategy
+}
+
+// SetCanonicalOrder controls the package setting 'CanonicalOrder'.
+func SetCanonicalOrder(canonical bool) {
+	config.CanonicalOrder = canonical
+}
+
+// SetAutoLastBuildDate controls the package setting 'AutoLastBuildDate'.
+func SetAutoLastBuildDate(auto bool) {
+	config.AutoLastBuildDate = auto
+}
+
+// SetStrictRFC controls the package setting 'StrictRFC'.
+func SetStrictRFC(strict bool) {
+	config.StrictRFC = strict
+}
+
+// SetMaxFetchBytes controls the package setting 'MaxFetchBytes'.
+func SetMaxFetchBytes(max int64) {
+	config.MaxFetchBytes = max
+}
+
+// SetMaxPages controls the package setting 'MaxPages'.
+func SetMaxPages(max int) {
+	config.MaxPages = max
+}
+
+// SetSanitizeHTML controls the package setting 'SanitizeHTML'.
+func SetSanitizeHTML(sanitize bool) {
+	config.SanitizeHTML = sanitize
+}
+
+// SetAllowedSchemes controls the package setting 'AllowedSchemes'.
+func SetAllowedSchemes(schemes []string) {
+	config.AllowedSchemes = schemes
+}
+
+// SetNormalizePercentEncoding controls the package setting
+// 'NormalizePercentEncoding'.
+func SetNormalizePercentEncoding(normalize bool) {
+	config.NormalizePercentEncoding = normalize
+}
+
+// SetOnItemError controls the package setting 'OnItemError'.
+func SetOnItemError(f func(raw string, err error)) {
+	config.OnItemError = f
+}
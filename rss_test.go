@@ -2,15 +2,31 @@ package rss
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestParseAsRSS(t *testing.T) {
+	notPermaLink := false
+
 	tests := []struct {
 		name    string
 		file    string
@@ -21,20 +37,30 @@ func TestParseAsRSS(t *testing.T) {
 			name: "well formed XML feed",
 			file: "test-data/rss-good.xml",
 			output: &Feed{
-				Title:       "A Nice Site",
-				Link:        "https://example.com",
-				Description: "A Nice Website",
-				PubDate:     time.Time{},
+				Title:           "A Nice Site",
+				Link:            "https://example.com",
+				Description:     "A Nice Website",
+				Language:        "en-US",
+				PubDate:         time.Time{},
+				LastBuildDate:   time.Date(2020, 3, 6, 18, 15, 47, 0, time.UTC),
+				UpdatePeriod:    "hourly",
+				UpdateFrequency: 1,
+				TTL:             time.Hour,
 				Items: []Item{
 					{
-						Title:       "Nice Title 1",
-						Link:        "https://example.com/2020/03/nice-title-1/",
-						Description: "<p>should we write something nice?</p>\n",
-						PubDate:     time.Date(2020, 3, 6, 18, 15, 47, 0, time.UTC),
-						GUID:        "https://example.com/?p=29611",
+						Title:           "Nice Title 1",
+						Link:            "https://example.com/2020/03/nice-title-1/",
+						Description:     "<p>should we write something nice?</p>\n",
+						PubDate:         time.Date(2020, 3, 6, 18, 15, 47, 0, time.UTC),
+						PubDateRaw:      "Fri, 06 Mar 2020 18:15:47 +0000",
+						GUID:            "https://example.com/?p=29611",
+						GUIDIsPermaLink: &notPermaLink,
+						Author:          "Joe Public",
+						Categories:      []Category{{Name: "Blogging"}},
 					},
 				},
-				Type: "RSS",
+				Type:     "RSS",
+				FeedType: FeedRSS,
 			},
 			success: true,
 		},
@@ -42,20 +68,26 @@ func TestParseAsRSS(t *testing.T) {
 			name: "rss feed with no XML declaration",
 			file: "test-data/rss-with-no-xml-declaration.xml",
 			output: &Feed{
-				Title:       "Nice title",
-				Link:        "https://blog.example.com/",
-				Description: "Recent content on example.com",
-				PubDate:     time.Date(2019, 4, 8, 10, 20, 30, 0, time.UTC),
+				Title:         "Nice title",
+				Link:          "https://blog.example.com/",
+				Self:          "https://blog.example.com/",
+				Description:   "Recent content on example.com",
+				Language:      "en-us",
+				PubDate:       time.Date(2019, 4, 8, 10, 20, 30, 0, time.UTC),
+				LastBuildDate: time.Date(2019, 4, 8, 10, 20, 30, 0, time.UTC),
+				Generator:     &Generator{Name: "Hugo -- gohugo.io"},
 				Items: []Item{
 					{
 						Title:       "My Nice Post",
 						Link:        "https://blog.example.com/post/nice/",
 						Description: "hi",
 						PubDate:     time.Date(2019, 4, 8, 10, 20, 33, 0, time.UTC),
+						PubDateRaw:  "Mon, 08 Apr 2019 10:20:33 +0000",
 						GUID:        "https://blog.example.com/post/nice/",
 					},
 				},
-				Type: "RSS",
+				Type:     "RSS",
+				FeedType: FeedRSS,
 			},
 			success: true,
 		},
@@ -68,19 +100,24 @@ func TestParseAsRSS(t *testing.T) {
 			name: "rss feed with invalid UTF-8",
 			file: "test-data/rss-with-invalid-utf8.xml",
 			output: &Feed{
-				Title:       "Nice title",
-				Link:        "https://example.com",
-				Description: "Nice description",
-				PubDate:     time.Time{},
+				Title:         "Nice title",
+				Link:          "https://example.com",
+				Description:   "Nice description",
+				Language:      "en-US",
+				PubDate:       time.Time{},
+				LastBuildDate: time.Date(2020, 3, 10, 16, 38, 45, 0, time.UTC),
 				Items: []Item{
 					{
 						Title:       "Post title",
 						Link:        "https://example.com/post-title/",
 						Description: "<p>hi</p>\nFollow us on\u00a0Facebook,\ufffd...\n",
 						PubDate:     time.Date(2020, 3, 9, 17, 25, 18, 0, time.UTC),
+						PubDateRaw:  "Mon, 09 Mar 2020 17:25:18 +0000",
+						Content:     "\nHi\n\nContact us at\nFollow us on\u00a0Facebook,\ufffd...\n",
 					},
 				},
-				Type: "RSS",
+				Type:     "RSS",
+				FeedType: FeedRSS,
 			},
 			success: true,
 		},
@@ -115,23 +152,59 @@ func TestParseAsRDF(t *testing.T) {
 			&Feed{
 				Title:       "Slashdot",
 				Link:        "https://slashdot.org/",
+				Self:        "http://rss.slashdot.org/slashdot/slashdotMain",
+				Hubs:        []string{"http://pubsubhubbub.appspot.com/"},
 				Description: "News for nerds, stuff that matters",
+				Language:    "en-us",
 				PubDate:     time.Date(2017, 1, 17, 21, 30, 14, 0, time.UTC),
+				Copyright:   "Copyright 1997-2016, SlashdotMedia. All Rights Reserved.",
+				Categories:  []Category{{Name: "Technology"}},
+				Image: &Image{
+					URL:   "http://a.fsdn.com/sd/topics/topicslashdot.gif",
+					Title: "Slashdot",
+					Link:  "https://slashdot.org/",
+				},
+				UpdatePeriod:    "hourly",
+				UpdateFrequency: 1,
+				TTL:             time.Hour,
 				Items: []Item{
 					{
-						Title:       "Uber Sues City of Seattle To Block Landmark Driver Union Ordinance",
-						Link:        "https://tech.slashdot.org/story/17/01/17/197230/uber-sues-city-of-seattle-to-block-landmark-driver-union-ordinance?utm_source=rss1.0mainlinkanon&utm_medium=feed",
-						Description: "Seattle's landmark law that lets drivers",
-						PubDate:     time.Date(2017, 1, 17, 20, 40, 0, 0, time.UTC),
+						Title:        "Uber Sues City of Seattle To Block Landmark Driver Union Ordinance",
+						Link:         "https://tech.slashdot.org/story/17/01/17/197230/uber-sues-city-of-seattle-to-block-landmark-driver-union-ordinance?utm_source=rss1.0mainlinkanon&utm_medium=feed",
+						Description:  "Seattle's landmark law that lets drivers",
+						PubDate:      time.Date(2017, 1, 17, 20, 40, 0, 0, time.UTC),
+						PubDateRaw:   "2017-01-17T20:40:00+00:00",
+						Author:       "msmash",
+						Copyright:    "Copyright 1997-2016, SlashdotMedia. All Rights Reserved.",
+						Categories:   []Category{{Name: "transportation"}},
+						CommentCount: 42,
+						Slash: &Slash{
+							Section:    "technology",
+							Department: "tussle-continues",
+							Comments:   42,
+							HitParade:  "42,42,27,22,3,0,0",
+						},
 					},
 					{
-						Title:       "Netflix is 'Killing' DVD Sales, Research Finds",
-						Link:        "https://entertainment.slashdot.org/story/17/01/17/1855219/netflix-is-killing-dvd-sales-research-finds?utm_source=rss1.0mainlinkanon&utm_medium=feed",
-						Description: "Netflix has become the go-to destination for many movie",
-						PubDate:     time.Date(2017, 1, 17, 20, 0, 0, 0, time.UTC),
+						Title:        "Netflix is 'Killing' DVD Sales, Research Finds",
+						Link:         "https://entertainment.slashdot.org/story/17/01/17/1855219/netflix-is-killing-dvd-sales-research-finds?utm_source=rss1.0mainlinkanon&utm_medium=feed",
+						Description:  "Netflix has become the go-to destination for many movie",
+						PubDate:      time.Date(2017, 1, 17, 20, 0, 0, 0, time.UTC),
+						PubDateRaw:   "2017-01-17T20:00:00+00:00",
+						Author:       "msmash",
+						Copyright:    "Copyright 1997-2016, SlashdotMedia. All Rights Reserved.",
+						Categories:   []Category{{Name: "movies"}},
+						CommentCount: 101,
+						Slash: &Slash{
+							Section:    "entertainment",
+							Department: "how-things-work",
+							Comments:   101,
+							HitParade:  "101,100,66,55,17,8,2",
+						},
 					},
 				},
-				Type: "RDF",
+				Type:     "RDF",
+				FeedType: FeedRDF,
 			},
 			true,
 		},
@@ -174,7 +247,8 @@ func TestParseAsAtom(t *testing.T) {
 			"test-data/atom-valid.xml",
 			&Feed{
 				Title:       "Test one two",
-				Link:        "http://www.example.com/atom.xml",
+				Link:        "http://www.example.com",
+				Self:        "http://www.example.com/atom.xml",
 				Description: "",
 				PubDate:     time.Date(2017, 1, 11, 20, 30, 23, 0, time.UTC),
 				Items: []Item{
@@ -182,18 +256,25 @@ func TestParseAsAtom(t *testing.T) {
 						Title:       "Test title 1",
 						Link:        "http://www.example.com/test-entry-1",
 						Description: "<p>Testing content 1</p>",
+						ContentType: "html",
 						PubDate:     time.Date(2017, 1, 11, 0, 0, 0, 0, time.UTC),
+						PubDateRaw:  "2017-01-11T00:00:00-00:00",
 						GUID:        "http://www.example.com/test-entry-1-id",
 					},
 					{
 						Title:       "Test title 2",
 						Link:        "http://www.example.com/test-entry-2",
 						Description: "<p>Testing content 2</p>",
+						ContentType: "html",
 						PubDate:     time.Date(2017, 1, 12, 0, 0, 0, 0, time.UTC),
+						PubDateRaw:  "2017-01-12T00:00:00-00:00",
 						GUID:        "http://www.example.com/test-entry-2-id",
 					},
 				},
-				Type: "Atom",
+				Type:     "Atom",
+				FeedType: FeedAtom,
+				ID:       "http://www.example.com-id",
+				Author:   "John Q. Public",
 			},
 			true,
 		},
@@ -204,7 +285,7 @@ func TestParseAsAtom(t *testing.T) {
 			buf, err := ioutil.ReadFile(test.file)
 			require.NoError(t, err, "read file")
 
-			feed, err := parseAsAtom(buf)
+			feed, err := parseAsAtom(context.Background(), buf)
 			if err != nil {
 				if !test.success {
 					return
@@ -269,14 +350,44 @@ func TestMakeXML(t *testing.T) {
       <link>https://www.example.com/1</link>
       <description>Item 1 is very nice</description>
       <pubDate>Sun, 25 Dec 2016 11:01:00 +0000</pubDate>
-      <guid>https://www.example.com/1</guid>
+      <guid isPermaLink="true">https://www.example.com/1</guid>
     </item>
     <item>
       <title>Nice item 2</title>
       <link>https://www.example.com/2</link>
       <description>Item 2 is very nice</description>
       <pubDate>Sun, 25 Dec 2016 10:01:00 +0000</pubDate>
-      <guid>https://www.example.com/2</guid>
+      <guid isPermaLink="true">https://www.example.com/2</guid>
+    </item>
+  </channel>
+</rss>`,
+			true,
+		},
+		{
+			"no dates",
+			Feed{
+				Title:       "Test feed",
+				Link:        "https://www.example.com/",
+				Description: "A nice feed",
+				Items: []Item{
+					{
+						Title:       "Nice item 1",
+						Link:        "https://www.example.com/1",
+						Description: "Item 1 is very nice",
+					},
+				},
+			},
+			`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Test feed</title>
+    <link>https://www.example.com/</link>
+    <description>A nice feed</description>
+    <item>
+      <title>Nice item 1</title>
+      <link>https://www.example.com/1</link>
+      <description>Item 1 is very nice</description>
+      <guid isPermaLink="true">https://www.example.com/1</guid>
     </item>
   </channel>
 </rss>`,
@@ -318,6 +429,78 @@ func TestParseTime(t *testing.T) {
 			"Sun, 09 Apr 2017 05:06 GMT",
 			time.Date(2017, time.April, 9, 5, 6, 0, 0, time.UTC),
 		},
+		{
+			"2024-01-02T03:04:05.123Z",
+			time.Date(2024, time.January, 2, 3, 4, 5, 123000000, time.UTC),
+		},
+		{
+			"2024-01-02T03:04:05.123456789+02:00",
+			time.Date(2024, time.January, 2, 1, 4, 5, 123456789, time.UTC),
+		},
+		{
+			"2024-01-02T03:04:05Z",
+			time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC),
+		},
+		{
+			"Mon, 2 Jan 2006 15:04:05 -0700",
+			time.Date(2006, time.January, 2, 22, 4, 5, 0, time.UTC),
+		},
+		{
+			"Mon, 2 Jan 2006 15:04:05 GMT",
+			time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC),
+		},
+		{
+			"2 Jan 2006 15:04:05 +0100",
+			time.Date(2006, time.January, 2, 14, 4, 5, 0, time.UTC),
+		},
+		{
+			"02 Jan 06 15:04 GMT",
+			time.Date(2006, time.January, 2, 15, 4, 0, 0, time.UTC),
+		},
+		{
+			"02 Jan 06 15:04 -0700",
+			time.Date(2006, time.January, 2, 22, 4, 0, 0, time.UTC),
+		},
+		{
+			"Mon, 02 Jan 06 15:04:05 +0000",
+			time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC),
+		},
+		{
+			"Monday, 02-Jan-06 15:04:05 GMT",
+			time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC),
+		},
+		{
+			"2006-01-02T15:04Z",
+			time.Date(2006, time.January, 2, 15, 4, 0, 0, time.UTC),
+		},
+		{
+			"2006-01-02T15:04+02:00",
+			time.Date(2006, time.January, 2, 13, 4, 0, 0, time.UTC),
+		},
+		{
+			"2006-01-02 15:04:05",
+			time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC),
+		},
+		{
+			"2006-01-02 15:04:05+02:00",
+			time.Date(2006, time.January, 2, 13, 4, 5, 0, time.UTC),
+		},
+		{
+			"2006-01-02 15:04:05 -0500",
+			time.Date(2006, time.January, 2, 20, 4, 5, 0, time.UTC),
+		},
+		{
+			"2006-01-02T15:04:05",
+			time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC),
+		},
+		{
+			"2006-01-02",
+			time.Date(2006, time.January, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"not a date",
+			time.Time{},
+		},
 	}
 
 	config.Verbose = true
@@ -333,3 +516,3590 @@ func TestParseTime(t *testing.T) {
 		}
 	}
 }
+
+func TestParseTimeErrors(t *testing.T) {
+	parsed, err := ParseTime(" 2024-01-02T03:04:05Z ")
+	require.NoError(t, err, "valid date")
+	assert.Equal(t, time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC),
+		parsed, "parsed")
+
+	_, err = ParseTime("")
+	assert.EqualError(t, err, "date is blank", "blank date")
+
+	_, err = ParseTime("sometime last week")
+	assert.EqualError(t, err,
+		"no format worked for date [sometime last week]", "bad date")
+}
+
+func TestITunesRoundTrip(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-itunes.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+
+	want := &ITunesFeed{
+		OwnerName:  "Jane Host",
+		OwnerEmail: "jane@podcast.example.com",
+		Type:       "serial",
+	}
+	assert.Equal(t, want, feed.ITunes, "parsed iTunes information")
+
+	out, err := makeXML(*feed)
+	require.NoError(t, err, "make XML")
+
+	feed2, err := ParseFeedXML(out)
+	require.NoError(t, err, "parse generated feed")
+	assert.Equal(t, want, feed2.ITunes, "round tripped iTunes information")
+}
+
+func TestExtensions(t *testing.T) {
+	SetCaptureExtensions(true)
+	defer SetCaptureExtensions(false)
+
+	buf, err := ioutil.ReadFile("test-data/rss-extensions.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+
+	ns := "https://example.com/ns/recipe"
+	assert.Equal(t, Extensions{{Space: ns, Name: "cuisine", Value: "Italian"}},
+		feed.Extensions, "channel extensions")
+
+	require.Len(t, feed.Items, 1, "item count")
+	want := Extensions{
+		{Space: ns, Name: "step", Value: "Mix flour and eggs"},
+		{Space: ns, Name: "note", Value: "Sift the flour"},
+		{Space: ns, Name: "step", Value: "Add milk"},
+		{Space: ns, Name: "step", Value: "Fry"},
+		{
+			Space: ns,
+			Name:  "serves",
+			Attrs: []xml.Attr{{Name: xml.Name{Local: "unit"}, Value: "people"}},
+			Value: "4",
+		},
+	}
+	assert.Equal(t, want, feed.Items[0].Extensions, "item extensions in order")
+
+	assert.Equal(t,
+		[]string{"Mix flour and eggs", "Add milk", "Fry"},
+		feed.Items[0].Extensions.Map()[xml.Name{Space: ns, Local: "step"}],
+		"repeated extensions in order in map")
+
+	SetCaptureExtensions(false)
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Nil(t, feed.Items[0].Extensions, "no extensions when setting off")
+}
+
+func TestCommentCount(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/atom-comments.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 3, "item count")
+
+	assert.Equal(t, 7, feed.Items[0].CommentCount, "count from thr:total")
+	assert.Equal(t, &Thread{Total: 7}, feed.Items[0].Thread, "thread")
+	assert.Nil(t, feed.Items[0].Slash, "no slash")
+
+	assert.Equal(t, 3, feed.Items[1].CommentCount,
+		"slash:comments takes precedence over thr:total")
+	assert.Equal(t, &Slash{Comments: 3}, feed.Items[1].Slash, "slash")
+	assert.Equal(t, &Thread{Total: 5}, feed.Items[1].Thread, "thread")
+
+	assert.Equal(t, 0, feed.Items[2].CommentCount, "no count")
+	assert.Nil(t, feed.Items[2].Slash, "no slash")
+	assert.Nil(t, feed.Items[2].Thread, "no thread")
+}
+
+func TestParseFeedsFromDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rss-batch-")
+	require.NoError(t, err, "create temporary directory")
+	defer os.RemoveAll(dir)
+
+	for src, dst := range map[string]string{
+		"test-data/rss-good.xml":   "b-rss.xml",
+		"test-data/atom-valid.xml": "c-atom.xml",
+	} {
+		buf, err := ioutil.ReadFile(src)
+		require.NoError(t, err, "read file")
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, dst), buf, 0644),
+			"write file")
+	}
+	require.NoError(t,
+		ioutil.WriteFile(filepath.Join(dir, "a-bad.xml"), []byte("nope"), 0644),
+		"write file")
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "subdir"), 0755),
+		"make directory")
+
+	feeds, errs := ParseFeedsFromDir(dir, BatchOptions{Concurrency: 2})
+	assert.Len(t, feeds, 2, "feed count")
+	assert.Equal(t, "RSS", feeds["b-rss.xml"].Type, "RSS feed parsed")
+	assert.Equal(t, "Atom", feeds["c-atom.xml"].Type, "Atom feed parsed")
+	assert.Len(t, errs, 1, "error count")
+	assert.Error(t, errs["a-bad.xml"], "bad file has error")
+
+	feeds, errs = ParseFeedsFromDir(dir, BatchOptions{StopOnError: true})
+	assert.Len(t, feeds, 0, "stopped before parsing feeds")
+	assert.Len(t, errs, 1, "error count")
+
+	feeds, errs = ParseFeedsFromDir(filepath.Join(dir, "missing"),
+		BatchOptions{})
+	assert.Len(t, feeds, 0, "no feeds from missing directory")
+	assert.Len(t, errs, 1, "error for missing directory")
+
+	// Make reading b-rss.xml fail twice before it works.
+	var mutex sync.Mutex
+	failures := 0
+	readFile = func(filename string) ([]byte, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if filepath.Base(filename) == "b-rss.xml" && failures < 2 {
+			failures++
+			return nil, fmt.Errorf("transient error")
+		}
+		return ioutil.ReadFile(filename)
+	}
+	defer func() { readFile = ioutil.ReadFile }()
+
+	feeds, errs = ParseFeedsFromDir(dir, BatchOptions{Retries: 1})
+	assert.Len(t, feeds, 1, "feed count without enough retries")
+	assert.Contains(t, errs["b-rss.xml"].Error(), "transient error",
+		"read error recorded")
+
+	failures = 0
+	feeds, errs = ParseFeedsFromDir(dir, BatchOptions{Retries: 2})
+	assert.Len(t, feeds, 2, "feed count with retries")
+	assert.NotContains(t, errs, "b-rss.xml", "read retried")
+	assert.Contains(t, errs, "a-bad.xml", "parse error not retried")
+}
+
+func TestParseAtomSource(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/atom-source.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 2, "item count")
+
+	assert.Equal(t,
+		&Source{
+			Title: "Example Blog",
+			ID:    "http://blog.example.org/",
+			Link:  "http://blog.example.org/feed.atom",
+		},
+		feed.Items[0].Source,
+		"source of aggregated entry")
+	assert.Nil(t, feed.Items[1].Source, "no source on local entry")
+}
+
+func TestAtomAlternateLinks(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/atom-entry-links.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, "https://example.com/", feed.Link, "feed alternate link")
+	assert.Equal(t, "https://example.com/feed.atom", feed.Self, "feed self link")
+
+	require.Len(t, feed.Items, 3, "item count")
+	assert.Equal(t, "https://example.com/1", feed.Items[0].Link,
+		"rel=alternate preferred")
+	assert.Equal(t, "https://example.com/2", feed.Items[1].Link,
+		"link without rel preferred")
+	assert.Equal(t, "https://api.example.com/entries/3/edit", feed.Items[2].Link,
+		"first link otherwise")
+}
+
+func TestAtomSummary(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/atom-summary.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 2, "item count")
+
+	assert.Equal(t, "Just the gist", feed.Items[0].Summary, "summary")
+	assert.Equal(t, "Just the gist", feed.Items[0].Description,
+		"description falls back to summary")
+	assert.Equal(t, "text", feed.Items[0].ContentType, "type of the summary")
+
+	assert.Equal(t, "<p>Short</p>", feed.Items[1].Summary, "summary")
+	assert.Equal(t, "<p>The whole thing</p>", feed.Items[1].Description,
+		"description from content")
+	assert.Equal(t, "html", feed.Items[1].ContentType, "type of the content")
+}
+
+func TestFeedUpdate(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2020, 3, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	feed := &Feed{
+		Title: "Old title",
+		Items: []Item{
+			{Title: "Edited", GUID: "1", Description: "old", PubDate: day(1)},
+			{Title: "Unchanged", GUID: "2", PubDate: day(2)},
+			{Title: "Only old", Link: "https://example.com/3", PubDate: day(3)},
+		},
+	}
+
+	newer := &Feed{
+		Title: "New title",
+		Items: []Item{
+			{Title: "New", GUID: "4", PubDate: day(4)},
+			{Title: "Edited", GUID: "1", Description: "new", PubDate: day(5)},
+			{Title: "Unchanged", GUID: "2", Description: "stale",
+				PubDate: day(1)},
+		},
+	}
+
+	feed.Update(newer)
+
+	assert.Equal(t, "Old title", feed.Title, "metadata unchanged")
+	assert.Equal(t,
+		[]Item{
+			{Title: "Edited", GUID: "1", Description: "new", PubDate: day(5)},
+			{Title: "New", GUID: "4", PubDate: day(4)},
+			{Title: "Only old", Link: "https://example.com/3", PubDate: day(3)},
+			{Title: "Unchanged", GUID: "2", PubDate: day(2)},
+		},
+		feed.Items,
+		"merged items")
+}
+
+func TestMakeAtomXML(t *testing.T) {
+	feed := Feed{
+		Title:       "Test feed",
+		Link:        "https://www.example.com/",
+		Description: "A nice feed",
+		PubDate:     time.Date(2016, 12, 25, 11, 0, 0, 0, time.UTC),
+		Items: []Item{
+			{
+				Title:       "Nice item 1",
+				Link:        "https://www.example.com/1",
+				Description: "<p>Item 1 is very nice</p>",
+				PubDate:     time.Date(2016, 12, 25, 11, 1, 0, 0, time.UTC),
+				GUID:        "tag:example.com,2016:1",
+			},
+			{
+				Title:   "Nice item 2",
+				Link:    "https://www.example.com/2",
+				PubDate: time.Date(2016, 12, 25, 10, 1, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	buf, err := makeAtomXML(feed)
+	require.NoError(t, err, "make Atom XML")
+
+	parsed, err := parseAsAtom(context.Background(), buf)
+	require.NoError(t, err, "parse generated Atom")
+
+	assert.Equal(t, feed.Title, parsed.Title, "title")
+	assert.Equal(t, feed.PubDate, parsed.PubDate, "updated")
+	assert.Equal(t, "urn:uuid:3d3ed9d2-aa3d-5fa6-90e8-ed662e90f559", parsed.ID,
+		"feed ID generated from link")
+	require.Len(t, parsed.Items, 2, "item count")
+	for i, item := range feed.Items {
+		assert.Equal(t, item.Title, parsed.Items[i].Title, "item title")
+		assert.Equal(t, item.Link, parsed.Items[i].Link, "item link")
+		assert.Equal(t, item.Description, parsed.Items[i].Description,
+			"item content")
+		assert.Equal(t, item.PubDate, parsed.Items[i].PubDate, "item updated")
+	}
+	assert.Equal(t, "tag:example.com,2016:1", parsed.Items[0].GUID,
+		"item ID used as is")
+	assert.Equal(t, "urn:uuid:b00e8c5c-a5a9-5408-bb2a-76df64f906cd",
+		parsed.Items[1].GUID, "item ID generated from link")
+
+	buf2, err := makeAtomXML(feed)
+	require.NoError(t, err, "make Atom XML")
+	assert.Equal(t, buf, buf2, "generated IDs are stable")
+}
+
+func TestMakeAtomXMLBareEntryIDs(t *testing.T) {
+	feed := Feed{
+		Title: "Test feed",
+		Link:  "https://www.example.com/",
+		Items: []Item{{}, {}},
+	}
+
+	buf, err := makeAtomXML(feed)
+	require.NoError(t, err, "make Atom XML")
+
+	parsed, err := parseAsAtom(context.Background(), buf)
+	require.NoError(t, err, "parse generated Atom")
+	require.Len(t, parsed.Items, 2, "item count")
+	assert.True(t, strings.HasPrefix(parsed.Items[0].GUID, "urn:uuid:"),
+		"ID generated")
+	assert.NotEqual(t, parsed.Items[0].GUID, parsed.Items[1].GUID,
+		"bare entries get different IDs")
+
+	buf2, err := makeAtomXML(feed)
+	require.NoError(t, err, "make Atom XML")
+	assert.Equal(t, buf, buf2, "generated IDs are stable")
+}
+
+func TestMakeAtomXMLDefaults(t *testing.T) {
+	feed := Feed{
+		Title:  "Test feed",
+		Link:   "https://www.example.com/",
+		Author: "Ed Itor",
+		Items: []Item{
+			{
+				Title:   "Newest",
+				Link:    "https://www.example.com/1",
+				Summary: "<p>Short</p>",
+				Author:  "Wri Ter",
+				PubDate: time.Date(2016, 12, 25, 11, 1, 0, 0, time.UTC),
+			},
+			{
+				Title: "Undated",
+				Link:  "https://www.example.com/2",
+			},
+		},
+	}
+
+	buf, err := makeAtomXML(feed)
+	require.NoError(t, err, "make Atom XML")
+
+	parsed, err := parseAsAtom(context.Background(), buf)
+	require.NoError(t, err, "parse generated Atom")
+	assert.Equal(t, feed.Items[0].PubDate, parsed.PubDate,
+		"feed updated from newest item")
+	assert.Equal(t, "Ed Itor", parsed.Author, "feed author")
+	require.Len(t, parsed.Items, 2, "item count")
+	assert.Equal(t, "Wri Ter", parsed.Items[0].Author, "entry author")
+	assert.Equal(t, "<p>Short</p>", parsed.Items[0].Summary, "entry summary")
+	assert.Equal(t, feed.Items[0].PubDate, parsed.Items[1].PubDate,
+		"entry updated from feed")
+	assert.Equal(t, "", parsed.Items[1].Author, "no entry author")
+}
+
+func TestNamespacePrefixesIgnored(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-unusual-prefixes.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+
+	assert.Equal(t,
+		&ITunesFeed{
+			OwnerName:  "Jane Host",
+			OwnerEmail: "jane@example.com",
+			Type:       "episodic",
+		},
+		feed.ITunes,
+		"iTunes elements with unusual prefix")
+	require.Len(t, feed.Items, 1, "item count")
+	assert.Equal(t, 12, feed.Items[0].CommentCount,
+		"slash elements with unusual prefix")
+	assert.Equal(t, &Thread{Total: 3}, feed.Items[0].Thread,
+		"thread elements with prefix declared on the element")
+
+	SetCaptureExtensions(true)
+	defer SetCaptureExtensions(false)
+
+	buf, err = ioutil.ReadFile("test-data/rdf-slashdot.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+
+	assert.Equal(t, "https://slashdot.org/", feed.Link,
+		"link not confused with atom10:link")
+	exts := feed.Extensions.Map()
+	assert.Len(t, exts[xml.Name{
+		Space: "http://rssnamespace.org/feedburner/ext/1.0",
+		Local: "info"}], 1, "feedburner: prefix resolves to its namespace")
+}
+
+func TestUpdateInterval(t *testing.T) {
+	hour := func(h int) time.Time {
+		return time.Date(2020, 3, 1, h, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		items []Item
+		want  time.Duration
+	}{
+		{
+			name: "no items",
+			want: 0,
+		},
+		{
+			name: "one dated item",
+			items: []Item{
+				{PubDate: hour(1)},
+				{},
+			},
+			want: 0,
+		},
+		{
+			name: "odd number of gaps",
+			items: []Item{
+				{PubDate: hour(20)},
+				{PubDate: hour(2)},
+				{},
+				{PubDate: hour(1)},
+				{PubDate: hour(4)},
+			},
+			want: 2 * time.Hour,
+		},
+		{
+			name: "even number of gaps",
+			items: []Item{
+				{PubDate: hour(1)},
+				{PubDate: hour(2)},
+				{PubDate: hour(5)},
+			},
+			want: 2 * time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			feed := &Feed{Items: test.items}
+			assert.Equal(t, test.want, feed.UpdateInterval(), "interval")
+		})
+	}
+}
+
+func TestRSSItemDates(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-conflicting-dates.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 5, "item count")
+
+	assert.Equal(t, time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC),
+		feed.Items[0].PubDate, "pubDate preferred when dates conflict")
+	assert.Equal(t, "Sat, 01 May 2021 10:00:00 +0000", feed.Items[0].PubDateRaw,
+		"raw date")
+
+	assert.Equal(t, time.Date(2021, 5, 2, 10, 0, 0, 0, time.UTC),
+		feed.Items[1].PubDate, "pubDate preferred when dates agree")
+
+	assert.Equal(t, time.Date(2021, 5, 3, 10, 0, 0, 0, time.UTC),
+		feed.Items[2].PubDate, "dc:date used when there is no pubDate")
+	assert.Equal(t, "2021-05-03T10:00:00Z", feed.Items[2].PubDateRaw, "raw date")
+
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		feed.Items[3].PubDate, "dc:date used when pubDate doesn't parse")
+	assert.Equal(t, "2020-01-02T03:04:05Z", feed.Items[3].PubDateRaw, "raw date")
+
+	assert.True(t, feed.Items[4].PubDate.IsZero(), "no date")
+
+	require.Len(t, feed.Warnings, 1, "warning count")
+	assert.Contains(t, feed.Warnings[0], "Conflicting", "warning about item")
+}
+
+func TestParseFeedXMLRaw(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-with-invalid-utf8.xml")
+	require.NoError(t, err, "read file")
+	require.False(t, utf8.Valid(buf), "fixture has invalid UTF-8")
+
+	input := append([]byte("\xef\xbb\xbf \n\t"), buf...)
+
+	feed, raw, err := ParseFeedXMLRaw(input)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, "Nice title", feed.Title, "title")
+
+	assert.True(t, bytes.HasPrefix(raw, []byte("<?xml ")),
+		"BOM and leading whitespace removed")
+	assert.True(t, utf8.Valid(raw), "invalid UTF-8 replaced")
+
+	feed2, err := ParseFeedXML(raw)
+	require.NoError(t, err, "parse normalized bytes")
+	assert.Equal(t, feed, feed2, "normalized bytes parse the same")
+}
+
+func TestParseFeedReader(t *testing.T) {
+	fh, err := os.Open("test-data/atom-valid.xml")
+	require.NoError(t, err, "open fixture")
+	defer fh.Close()
+
+	feed, err := ParseFeedReader(fh)
+	require.NoError(t, err, "parse feed")
+
+	buf, err := ioutil.ReadFile("test-data/atom-valid.xml")
+	require.NoError(t, err, "read fixture")
+	feed2, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse bytes")
+	assert.Equal(t, feed2, feed, "same as parsing the bytes")
+
+	_, err = ParseFeedReader(errReader{})
+	require.Error(t, err, "read error")
+	assert.Contains(t, err.Error(), "boom", "read error returned")
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, fmt.Errorf("boom") }
+
+func TestParsedGUID(t *testing.T) {
+	tests := []struct {
+		guid      string
+		authority string
+		date      string
+		specific  string
+		ok        bool
+	}{
+		{"tag:example.com,2024:post-1", "example.com", "2024", "post-1", true},
+		{"TAG:example.com,2024-01-02:a:b#c", "example.com", "2024-01-02", "a:b#c",
+			true},
+		{"tag:jane@example.com,2001-09:x", "jane@example.com", "2001-09", "x",
+			true},
+		{"tag:example.com,2024:", "example.com", "2024", "", true},
+		{"https://example.com/post-1", "", "", "", false},
+		{"tag:example.com:post-1", "", "", "", false},
+		{"tag:example.com,24:post-1", "", "", "", false},
+		{"tag:,2024:post-1", "", "", "", false},
+		{"", "", "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.guid, func(t *testing.T) {
+			item := &Item{GUID: test.guid}
+			authority, date, specific, ok := item.ParsedGUID()
+			assert.Equal(t, test.ok, ok, "ok")
+			assert.Equal(t, test.authority, authority, "authority")
+			assert.Equal(t, test.date, date, "date")
+			assert.Equal(t, test.specific, specific, "specific")
+		})
+	}
+}
+
+func TestScrapeHTML(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/html-not-a-feed.html")
+	require.NoError(t, err, "read file")
+
+	_, err = ParseFeedXML(buf)
+	assert.Error(t, err, "HTML is not a feed")
+
+	SetScrape(true)
+	defer SetScrape(false)
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "scrape HTML")
+
+	assert.Equal(t, "HTML", feed.Type, "type")
+	assert.Equal(t, FeedHTML, feed.FeedType, "feed type")
+	assert.Equal(t, "A Nice Blog", feed.Title, "title")
+	assert.Len(t, feed.Warnings, 1, "warning about scraping")
+	assert.Equal(t,
+		[]Item{
+			{Title: "First post", Link: "https://blog.example.com/first"},
+			{Title: "Second post", Link: "/second"},
+		},
+		feed.Items,
+		"items from links")
+
+	_, err = ParseFeedXML([]byte("nope"))
+	assert.Error(t, err, "nothing to scrape")
+}
+
+func TestChannelCategoriesRoundTrip(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-channel-categories.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+
+	want := []Category{
+		{Name: "Technology"},
+		{Name: "Computers/Software/Internet", Domain: "http://www.dmoz.org"},
+	}
+	assert.Equal(t, want, feed.Categories, "parsed categories")
+
+	out, err := makeXML(*feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(out),
+		`<category domain="http://www.dmoz.org">Computers/Software/Internet</category>`,
+		"category with domain in output")
+
+	feed2, err := ParseFeedXML(out)
+	require.NoError(t, err, "parse generated feed")
+	assert.Equal(t, want, feed2.Categories, "round tripped categories")
+}
+
+func TestItemCategories(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-item-categories.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 3, "item count")
+
+	want := []Category{
+		{Name: "Go"},
+		{Name: "Programming", Domain: "https://example.com/tags"},
+	}
+	assert.Equal(t, want, feed.Items[0].Categories, "RSS item categories")
+	assert.Nil(t, feed.Items[2].Categories, "untagged item")
+
+	out, err := makeXML(*feed)
+	require.NoError(t, err, "make XML")
+	feed2, err := ParseFeedXML(out)
+	require.NoError(t, err, "parse generated feed")
+	assert.Equal(t, want, feed2.Items[0].Categories, "round tripped categories")
+	assert.Equal(t, []Category{{Name: "Food"}}, feed2.Items[1].Categories,
+		"round tripped categories of second item")
+
+	buf, err = ioutil.ReadFile("test-data/atom-categories.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse Atom feed")
+	assert.Equal(t, []Category{{Name: "Blog"}}, feed.Categories,
+		"Atom feed categories")
+	require.Len(t, feed.Items, 1, "item count")
+	assert.Equal(t, want, feed.Items[0].Categories, "Atom entry categories")
+}
+
+func TestParseRating(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-media-rating.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 5, "item count")
+
+	tests := []struct {
+		rating string
+		adult  bool
+	}{
+		{"adult", true},
+		{"nonadult", false},
+		{"r", false},
+		{"", true},
+		{"", false},
+	}
+
+	for i, test := range tests {
+		assert.Equal(t, test.rating, feed.Items[i].Rating,
+			"rating of %s", feed.Items[i].Title)
+		assert.Equal(t, test.adult, feed.Items[i].AdultContent,
+			"adult content of %s", feed.Items[i].Title)
+	}
+}
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		name string
+		feed Feed
+		want string
+	}{
+		{
+			name: "self preferred",
+			feed: Feed{
+				Self: "https://Example.COM:443/feed/",
+				Link: "https://example.com/",
+			},
+			want: "https://example.com/feed",
+		},
+		{
+			name: "link when no self",
+			feed: Feed{Link: "HTTP://www.example.com:80/#top"},
+			want: "http://www.example.com",
+		},
+		{
+			name: "non-default port kept",
+			feed: Feed{Link: "http://example.com:8080/rss?x=1"},
+			want: "http://example.com:8080/rss?x=1",
+		},
+		{
+			name: "no URL",
+			feed: Feed{},
+			want: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, test.feed.CanonicalURL(), "canonical URL")
+		})
+	}
+}
+
+func TestSuggestedInterval(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rdf-slashdot.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, time.Hour, feed.SuggestedInterval(), "Slashdot interval")
+
+	tests := []struct {
+		period    string
+		frequency int
+		want      time.Duration
+	}{
+		{"", 0, 0},
+		{"daily", 2, 12 * time.Hour},
+		{"weekly", 0, 7 * 24 * time.Hour},
+		{"", 4, 6 * time.Hour},
+		{"fortnightly", 1, 0},
+	}
+
+	for _, test := range tests {
+		feed := &Feed{UpdatePeriod: test.period, UpdateFrequency: test.frequency}
+		assert.Equal(t, test.want, feed.SuggestedInterval(),
+			"interval for %s/%d", test.period, test.frequency)
+	}
+}
+
+func TestSkipEmptyItems(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-empty-item.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Len(t, feed.Items, 3, "empty item kept by default")
+	assert.Nil(t, feed.Warnings, "no warnings")
+
+	SetSkipEmptyItems(true)
+	defer SetSkipEmptyItems(false)
+
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 2, "empty item dropped")
+	assert.Equal(t, "Real post", feed.Items[0].Title, "first item")
+	assert.Equal(t, "Untitled but has a description", feed.Items[1].Description,
+		"second item")
+	assert.Equal(t, []string{"skipped 1 empty item(s)"}, feed.Warnings,
+		"warning")
+}
+
+func TestParseBOMWhitespaceAndEncoding(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-bom-whitespace-windows-1252.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+
+	assert.Equal(t, "Café News", feed.Title, "title decoded")
+	assert.Equal(t, "Crème brûlée “quoted”", feed.Description,
+		"description decoded")
+	require.Len(t, feed.Items, 1, "item count")
+	assert.Equal(t, "Menü", feed.Items[0].Title, "item title decoded")
+}
+
+func TestPlaintext(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"<p>One</p><p>Two &amp; <b>thr</b>ee</p>", "One Two & three"},
+		{"a<br>b<script>alert(1)</script> c<style>p {}</style>", "a b c"},
+		{"  spaced\n\tout  ", "spaced out"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, plaintext(test.input), "plaintext(%q)",
+			test.input)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	feed := &Feed{
+		Items: []Item{
+			{
+				Title:       `Say "hi", please`,
+				Link:        "https://example.com/1",
+				Description: "<p>Line one</p>\n<p>Line two</p>",
+				PubDate:     time.Date(2020, 3, 6, 18, 15, 47, 0, time.UTC),
+				GUID:        "1",
+			},
+			{
+				Title: "No date",
+				Link:  "https://example.com/2",
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, feed.WriteCSV(&buf), "write CSV")
+
+	assert.Equal(t,
+		"title,link,pubDate,guid,description\r\n"+
+			`"Say ""hi"", please",https://example.com/1,2020-03-06T18:15:47Z,1,`+
+			"Line one Line two\r\n"+
+			"No date,https://example.com/2,,,\r\n",
+		buf.String(),
+		"CSV")
+}
+
+func TestHubs(t *testing.T) {
+	tests := []struct {
+		file string
+		hubs []string
+	}{
+		{"test-data/rss-hubs.xml", []string{"https://hub.example.com/",
+			"https://hub2.example.com/"}},
+		{"test-data/rdf-slashdot.xml", []string{"http://pubsubhubbub.appspot.com/"}},
+		{"test-data/atom-hubs.xml", []string{"https://hub.example.com/"}},
+		{"test-data/atom-valid.xml", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			buf, err := ioutil.ReadFile(test.file)
+			require.NoError(t, err, "read file")
+
+			feed, err := ParseFeedXML(buf)
+			require.NoError(t, err, "parse feed")
+			assert.Equal(t, test.hubs, feed.Hubs, "hubs")
+		})
+	}
+}
+
+func TestMaxContentBytes(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-oversized-content.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 1, "item count")
+	assert.Len(t, feed.Items[0].Content, 130, "content kept by default")
+	assert.Nil(t, feed.Warnings, "no warnings")
+
+	SetMaxContentBytes(64)
+	defer SetMaxContentBytes(0)
+
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, "Short summary", feed.Items[0].Description,
+		"small description unchanged")
+	assert.Equal(t, "<p>Big image: <img src=\"data:image/png;base64,AAAAAAAAAAAAA"+
+		"AAAAA", feed.Items[0].Content, "content truncated")
+	assert.Len(t, feed.Warnings, 1, "warning")
+
+	SetDropOversizedContent(true)
+	defer SetDropOversizedContent(false)
+
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, "", feed.Items[0].Content, "content dropped")
+	assert.Len(t, feed.Warnings, 1, "warning")
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	assert.Equal(t, "abc", truncateUTF8("abc", 5), "short string unchanged")
+	assert.Equal(t, "ab", truncateUTF8("abc", 2), "ASCII")
+	assert.Equal(t, "a", truncateUTF8("aé", 2), "don't split a character")
+	assert.Equal(t, "aé", truncateUTF8("aéb", 3), "whole character kept")
+}
+
+func TestAtomTypedText(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/atom-typed-text.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+
+	assert.Equal(t, "A feed about <em>markup</em> &amp; more", feed.Description,
+		"html subtitle")
+	require.Len(t, feed.Items, 2, "item count")
+	assert.Equal(t, "<p>Some <b>bold</b> text</p>", feed.Items[0].Description,
+		"xhtml content")
+	assert.Equal(t, "xhtml", feed.Items[0].ContentType, "xhtml type")
+	assert.Equal(t, "Plain text", feed.Items[1].Description, "text content")
+	assert.Equal(t, "text", feed.Items[1].ContentType, "text is the default")
+
+	feed, err = ParseFeedXML([]byte(`<feed xmlns="http://www.w3.org/2005/Atom">
+<title type="html">Tips &amp;amp; &lt;b&gt;tricks&lt;/b&gt;</title>
+<subtitle>Why 1 &lt; 2 &amp; more</subtitle>
+</feed>`))
+	require.NoError(t, err, "parse feed with typed title")
+	assert.Equal(t, "Tips & tricks", feed.Title, "html title as plain text")
+	assert.Equal(t, "Why 1 &lt; 2 &amp; more", feed.Description,
+		"text subtitle escaped")
+}
+
+func TestValidateFeedURL(t *testing.T) {
+	tests := []struct {
+		input   string
+		output  string
+		private bool
+		isError bool
+	}{
+		{"HTTPS://Example.COM:443/feed.xml#top", "https://example.com/feed.xml",
+			false, false},
+		{" http://example.com/feed/ ", "http://example.com/feed/", false, false},
+		{"ftp://example.com/feed.xml", "", false, true},
+		{"example.com/feed.xml", "", false, true},
+		{"http:///feed.xml", "", false, true},
+		{"http://localhost/feed.xml", "", false, true},
+		{"http://127.0.0.1:8080/feed.xml", "", false, true},
+		{"http://10.1.2.3/feed.xml", "", false, true},
+		{"http://192.168.0.1/feed.xml", "", false, true},
+		{"http://169.254.169.254/latest/", "", false, true},
+		{"http://[::1]/feed.xml", "", false, true},
+		{"http://[fd00::1]/feed.xml", "", false, true},
+		{"http://224.0.0.1/feed.xml", "", false, true},
+		{"http://255.255.255.255/feed.xml", "", false, true},
+		{"http://[ff02::1]/feed.xml", "", false, true},
+		{"http://[::ffff:127.0.0.1]/feed.xml", "", false, true},
+		{"http://2130706433/feed.xml", "", false, true},
+		{"http://0x7f.1/feed.xml", "", false, true},
+		{"http://0x7f000001/feed.xml", "", true, true},
+		{"http://127.1/feed.xml", "", true, true},
+		{"http://93.184.216.34/feed.xml", "http://93.184.216.34/feed.xml", false,
+			false},
+		{"http://1password.com/feed.xml", "http://1password.com/feed.xml", false,
+			false},
+		{"http://10.1.2.3/feed.xml", "http://10.1.2.3/feed.xml", true, false},
+		{"http://localhost/feed.xml", "http://localhost/feed.xml", true, false},
+	}
+
+	defer SetAllowPrivateHosts(false)
+
+	for _, test := range tests {
+		SetAllowPrivateHosts(test.private)
+		output, err := ValidateFeedURL(test.input)
+		if test.isError {
+			assert.Error(t, err, test.input)
+			continue
+		}
+		if assert.NoError(t, err, test.input) {
+			assert.Equal(t, test.output, output, test.input)
+		}
+	}
+}
+
+func TestDenyPrivateAddresses(t *testing.T) {
+	assert.Error(t, DenyPrivateAddresses("tcp", "127.0.0.1:80", nil), "loopback")
+	assert.Error(t, DenyPrivateAddresses("tcp", "10.0.0.1:443", nil), "private")
+	assert.Error(t, DenyPrivateAddresses("tcp6", "[::1]:80", nil),
+		"IPv6 loopback")
+	assert.Error(t, DenyPrivateAddresses("tcp", "nonsense", nil), "no port")
+	assert.NoError(t, DenyPrivateAddresses("tcp", "93.184.216.34:443", nil),
+		"public")
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, `<rss version="2.0"><channel><title>Local</title>
+<link>https://example.com/</link><description>d</description></channel></rss>`)
+		}))
+	defer server.Close()
+
+	_, err := FetchFeed(context.Background(), nil, server.URL)
+	assert.NoError(t, err, "not checked by default")
+
+	SetCheckDialedAddress(true)
+	defer SetCheckDialedAddress(false)
+
+	_, err = FetchFeed(context.Background(), nil, server.URL)
+	assert.Error(t, err, "loopback refused")
+
+	_, err = FetchFeed(context.Background(), server.Client(), server.URL)
+	assert.NoError(t, err, "caller's client used as is")
+}
+
+func TestGeneratorRoundTrip(t *testing.T) {
+	feed := Feed{
+		Title:     "Generated",
+		Link:      "https://example.com/",
+		PubDate:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Generator: &Generator{Name: "Gen", URI: "https://gen.example/", Version: "1.2"},
+	}
+
+	rssXML, err := makeXML(feed)
+	require.NoError(t, err, "make RSS")
+	parsed, err := ParseFeedXML(rssXML)
+	require.NoError(t, err, "parse RSS")
+	assert.Equal(t, &Generator{Name: "Gen"}, parsed.Generator,
+		"RSS has only a name")
+
+	atomXML, err := makeAtomXML(feed)
+	require.NoError(t, err, "make Atom")
+	parsed, err = ParseFeedXML(atomXML)
+	require.NoError(t, err, "parse Atom")
+	assert.Equal(t, feed.Generator, parsed.Generator, "Atom generator")
+
+	SetDefaultGenerator("My app")
+	defer SetDefaultGenerator("")
+
+	feed.Generator = nil
+	rssXML, err = makeXML(feed)
+	require.NoError(t, err, "make RSS")
+	parsed, err = ParseFeedXML(rssXML)
+	require.NoError(t, err, "parse RSS")
+	assert.Equal(t, &Generator{Name: "My app"}, parsed.Generator,
+		"default generator")
+}
+
+func TestParseLinkHeader(t *testing.T) {
+	header := `<https://hub.example.com/>; rel="hub", ` +
+		`<https://example.com/feed.xml>; rel=self; type="application/rss+xml", ` +
+		`<https://example.com/a,b>; rel="alternate hub"; title="A \"quoted\", title"`
+
+	links := ParseLinkHeader(header)
+	require.Len(t, links, 3, "link count")
+
+	assert.Equal(t, WebLink{
+		URL:    "https://hub.example.com/",
+		Rels:   []string{"hub"},
+		Params: map[string]string{},
+	}, links[0], "hub")
+	assert.Equal(t, WebLink{
+		URL:    "https://example.com/feed.xml",
+		Rels:   []string{"self"},
+		Params: map[string]string{"type": "application/rss+xml"},
+	}, links[1], "self")
+	assert.Equal(t, WebLink{
+		URL:    "https://example.com/a,b",
+		Rels:   []string{"alternate", "hub"},
+		Params: map[string]string{"title": `A "quoted", title`},
+	}, links[2], "quoted title")
+	assert.True(t, links[2].HasRel("HUB"), "has rel")
+	assert.False(t, links[2].HasRel("self"), "does not have rel")
+
+	assert.Nil(t, ParseLinkHeader(""), "empty header")
+	assert.Nil(t, ParseLinkHeader("garbage"), "no links")
+}
+
+func TestEncodedSize(t *testing.T) {
+	feed := &Feed{
+		Title:   "Sized",
+		Link:    "https://example.com/",
+		PubDate: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Items: []Item{
+			{
+				Title:       "One",
+				Link:        "https://example.com/1",
+				Description: "<p>Hi</p>",
+				PubDate:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			},
+		},
+	}
+
+	rssXML, err := makeXML(*feed)
+	require.NoError(t, err, "make RSS")
+	size, err := feed.EncodedSize("rss")
+	require.NoError(t, err, "RSS size")
+	assert.Equal(t, len(rssXML), size, "RSS size")
+
+	atomXML, err := makeAtomXML(*feed)
+	require.NoError(t, err, "make Atom")
+	size, err = feed.EncodedSize("atom")
+	require.NoError(t, err, "Atom size")
+	assert.Equal(t, len(atomXML), size, "Atom size")
+
+	_, err = feed.EncodedSize("json")
+	assert.Error(t, err, "unknown format")
+}
+
+func TestPodcastNamespace(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-podcast-namespace.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 2, "item count")
+
+	assert.Equal(t, &PodcastItem{
+		TranscriptURL:  "https://podcast.example.com/2.vtt",
+		TranscriptType: "text/vtt",
+		ChaptersURL:    "https://podcast.example.com/2.json",
+		ChaptersType:   "application/json+chapters",
+	}, feed.Items[0].Podcast, "podcast elements")
+	assert.Nil(t, feed.Items[1].Podcast, "no podcast elements")
+}
+
+func TestTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		channel  string
+		ttl      time.Duration
+		interval time.Duration
+	}{
+		{"ttl", `<ttl>90</ttl>`, 90 * time.Minute, 90 * time.Minute},
+		{"syndication", `<sy:updatePeriod>daily</sy:updatePeriod>
+    <sy:updateFrequency>4</sy:updateFrequency>`, 6 * time.Hour, 6 * time.Hour},
+		{"ttl preferred", `<ttl>30</ttl>
+    <sy:updatePeriod>daily</sy:updatePeriod>`, 30 * time.Minute,
+			30 * time.Minute},
+		{"bad ttl", `<ttl>soon</ttl>`, 0, time.Hour},
+		{"neither", ``, 0, time.Hour},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			feed, err := ParseFeedXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:sy="http://purl.org/rss/1.0/modules/syndication/">
+  <channel>
+    <title>TTL</title>
+    <link>https://example.com/</link>
+    <description>How often to fetch</description>
+    ` + test.channel + `
+  </channel>
+</rss>`))
+			require.NoError(t, err, "parse feed")
+			assert.Equal(t, test.ttl, feed.TTL, "TTL")
+			assert.Equal(t, test.interval, feed.SuggestedRefreshInterval(),
+				"refresh interval")
+		})
+	}
+}
+
+func TestFilterByCategory(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-item-categories.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 3, "item count")
+	assert.Equal(t, []Category{
+		{Name: "Go"},
+		{Name: "Programming", Domain: "https://example.com/tags"},
+	}, feed.Items[0].Categories, "item categories")
+
+	filtered := feed.FilterByCategory("programming", "FOOD")
+	require.Len(t, filtered.Items, 2, "filtered item count")
+	assert.Equal(t, "Go post", filtered.Items[0].Title, "first match")
+	assert.Equal(t, "Cooking post", filtered.Items[1].Title, "second match")
+	assert.Equal(t, feed.Title, filtered.Title, "feed metadata kept")
+	assert.Len(t, feed.Items, 3, "original unchanged")
+
+	assert.Empty(t, feed.FilterByCategory("blog").Items,
+		"feed categories not matched")
+}
+
+func TestItemsBetween(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(7 * 24 * time.Hour)
+
+	feed := &Feed{
+		Items: []Item{
+			{Title: "Before", PubDate: start.Add(-time.Nanosecond)},
+			{Title: "At start", PubDate: start},
+			{Title: "Middle", PubDate: start.Add(3 * 24 * time.Hour)},
+			{Title: "Just before end", PubDate: end.Add(-time.Nanosecond)},
+			{Title: "At end", PubDate: end},
+			{Title: "No date"},
+		},
+	}
+
+	var titles []string
+	for _, item := range feed.ItemsBetween(start, end) {
+		titles = append(titles, item.Title)
+	}
+	assert.Equal(t, []string{"At start", "Middle", "Just before end"}, titles,
+		"items in window")
+
+	assert.Empty(t, feed.ItemsBetween(end, start), "empty window")
+
+	items := feed.ItemsBetween(time.Time{}, start)
+	require.Len(t, items, 1, "zero dates excluded")
+	assert.Equal(t, "Before", items[0].Title, "dated item included")
+}
+
+func TestDedupeByTitle(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	feed := &Feed{
+		Items: []Item{
+			{Title: "Go 1.14 is released!", Link: "https://b.example.com/1",
+				PubDate: day(3)},
+			{Title: "Rust 1.42 released", PubDate: day(2)},
+			{Title: "go 1.14 is released", Link: "https://a.example.com/1",
+				PubDate: day(1)},
+			{Title: "Go 1.14 is released", Link: "https://c.example.com/1"},
+			{Title: "", Link: "https://a.example.com/2"},
+			{Title: "", Link: "https://a.example.com/3"},
+		},
+	}
+
+	feed.DedupeByTitle(0)
+
+	var links []string
+	for _, item := range feed.Items {
+		links = append(links, item.Link)
+	}
+	assert.Equal(t, []string{
+		"",
+		"https://a.example.com/1",
+		"https://a.example.com/2",
+		"https://a.example.com/3",
+	}, links, "earliest kept in original order, untitled items kept")
+
+	feed = &Feed{
+		Items: []Item{
+			{Title: "Apple announces new laptop"},
+			{Title: "Apple announces new laptop today"},
+		},
+	}
+	feed.DedupeByTitle(0.9)
+	assert.Len(t, feed.Items, 2, "4/5 similar is below 0.9")
+	feed.DedupeByTitle(0.8)
+	assert.Len(t, feed.Items, 1, "4/5 similar is at 0.8")
+	assert.Equal(t, "Apple announces new laptop", feed.Items[0].Title,
+		"first kept when neither has a date")
+}
+
+func TestMergeFeeds(t *testing.T) {
+	rssFeed, err := ParseFeedXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Mirror one</title>
+    <link>https://one.example.com/</link>
+    <description>The first mirror</description>
+    <item>
+      <title>First</title>
+      <guid isPermaLink="false">post-1</guid>
+      <pubDate>Wed, 01 Jan 2020 00:00:00 +0000</pubDate>
+    </item>
+    <item>
+      <title>No GUID</title>
+      <link>https://example.com/posts/2</link>
+      <pubDate>Thu, 02 Jan 2020 00:00:00 +0000</pubDate>
+    </item>
+    <item>
+      <title>Title only</title>
+      <pubDate>Fri, 03 Jan 2020 00:00:00 +0000</pubDate>
+    </item>
+  </channel>
+</rss>`))
+	require.NoError(t, err, "parse RSS feed")
+
+	atomFeed, err := ParseFeedXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Mirror two</title>
+  <id>urn:example:two</id>
+  <updated>2020-01-05T00:00:00Z</updated>
+  <entry>
+    <title>First, again</title>
+    <id>post-1</id>
+    <updated>2020-01-05T00:00:00Z</updated>
+  </entry>
+  <entry>
+    <title>No GUID, again</title>
+    <link href="https://example.com/posts/2"/>
+    <updated>2020-01-05T00:00:00Z</updated>
+  </entry>
+  <entry>
+    <title>Title only</title>
+    <updated>2020-01-05T00:00:00Z</updated>
+  </entry>
+  <entry>
+    <title>Fourth</title>
+    <id>post-4</id>
+    <updated>2020-01-04T00:00:00Z</updated>
+  </entry>
+</feed>`))
+	require.NoError(t, err, "parse Atom feed")
+	// Atom entries always have an ID. Clear them so we match on the others.
+	atomFeed.Items[1].GUID = ""
+	atomFeed.Items[2].GUID = ""
+
+	merged := MergeFeeds(nil, rssFeed, atomFeed)
+	require.NotNil(t, merged, "merged feed")
+	assert.Equal(t, "Mirror one", merged.Title, "metadata from the first feed")
+	assert.Equal(t, "RSS", merged.Type, "type from the first feed")
+
+	var titles []string
+	for _, item := range merged.Items {
+		titles = append(titles, item.Title)
+	}
+	assert.Equal(t, []string{"Fourth", "Title only", "No GUID", "First"}, titles,
+		"earliest seen kept, newest first")
+	assert.Len(t, rssFeed.Items, 3, "inputs unchanged")
+
+	merged = MergeFeeds(&Feed{Items: []Item{{Description: "a"}}},
+		&Feed{Items: []Item{{Description: "b"}}})
+	assert.Len(t, merged.Items, 2, "items without a key are kept")
+
+	assert.Nil(t, MergeFeeds(), "no feeds")
+	assert.Nil(t, MergeFeeds(nil, nil), "only nil feeds")
+}
+
+func TestInheritAuthor(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-channel-author.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, "editor@example.com (Ed Itor)", feed.Author, "feed author")
+	require.Len(t, feed.Items, 2, "item count")
+	assert.Equal(t, "", feed.Items[0].Author, "not inherited by default")
+	assert.Equal(t, "writer@example.com (Wri Ter)", feed.Items[1].Author,
+		"item author")
+
+	SetInheritAuthor(true)
+	defer SetInheritAuthor(false)
+
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, "editor@example.com (Ed Itor)", feed.Items[0].Author,
+		"inherited")
+	assert.Equal(t, "writer@example.com (Wri Ter)", feed.Items[1].Author,
+		"own author kept")
+}
+
+func TestLenientDates(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-bogus-timezones.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.True(t, feed.PubDate.IsZero(), "channel date not parsed by default")
+	require.Len(t, feed.Items, 3, "item count")
+	assert.True(t, feed.Items[0].PubDate.IsZero(), "item date not parsed")
+	assert.Equal(t, []string{
+		"item [Offset and zone name]: no format worked for date [Sun, 30 Jun 2013 21:26:26 +0000 UTC]",
+		"item [Zone with offset]: no format worked for date [Sat, 29 Jun 2013 18:20:00 GMT+00:00]",
+		"item [Nonsense]: no format worked for date [sometime last week]",
+	}, feed.Warnings, "bad item dates recorded")
+
+	SetLenient(true)
+	defer SetLenient(false)
+
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, time.Date(2013, 6, 30, 19, 26, 26, 0, time.UTC), feed.PubDate,
+		"channel date uses offset")
+	require.Len(t, feed.Items, 3, "item count")
+	assert.Equal(t, time.Date(2013, 6, 30, 21, 26, 26, 0, time.UTC),
+		feed.Items[0].PubDate, "offset and zone name")
+	assert.Equal(t, time.Date(2013, 6, 29, 18, 20, 0, 0, time.UTC),
+		feed.Items[1].PubDate, "zone with offset")
+	assert.True(t, feed.Items[2].PubDate.IsZero(), "nonsense not parsed")
+	require.Len(t, feed.Warnings, 4, "warnings")
+	assert.Equal(t,
+		"item [Nonsense]: no format worked for date [sometime last week]",
+		feed.Warnings[3], "nonsense recorded")
+}
+
+func TestGUIDStrategy(t *testing.T) {
+	feed := Feed{
+		Title: "Test feed",
+		Link:  "https://www.example.com/",
+		Items: []Item{
+			{Title: "No GUID", Link: "https://www.example.com/1"},
+			{Title: "GUID", Link: "https://www.example.com/2", GUID: "item-2"},
+		},
+	}
+
+	buf, err := makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(buf),
+		`<guid isPermaLink="true">https://www.example.com/1</guid>`,
+		"link used by default")
+	assert.Contains(t, string(buf),
+		`<guid isPermaLink="false">item-2</guid>`, "item GUID used if it has one")
+
+	SetGUIDStrategy(GUIDOmit)
+	defer SetGUIDStrategy(GUIDLink)
+
+	buf, err = makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Equal(t, 1, bytes.Count(buf, []byte("<guid ")), "one guid")
+	assert.Contains(t, string(buf), `<guid isPermaLink="false">item-2</guid>`,
+		"item GUID used")
+}
+
+func TestGUIDRoundTrip(t *testing.T) {
+	notPermaLink := false
+	feed := Feed{
+		Title:       "Live updates",
+		Link:        "https://www.example.com/",
+		Description: "Several items share a link",
+		Items: []Item{
+			{
+				Title:           "Update 2",
+				Link:            "https://www.example.com/live",
+				GUID:            "urn:example:live:2",
+				GUIDIsPermaLink: &notPermaLink,
+			},
+			{
+				Title:           "Update 1",
+				Link:            "https://www.example.com/live",
+				GUID:            "urn:example:live:1",
+				GUIDIsPermaLink: &notPermaLink,
+			},
+			{
+				Title: "No GUID",
+				Link:  "https://www.example.com/other",
+			},
+		},
+	}
+
+	buf, err := makeXML(feed)
+	require.NoError(t, err, "make XML")
+
+	parsed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse generated RSS")
+	require.Len(t, parsed.Items, 3, "item count")
+	assert.Equal(t, "urn:example:live:2", parsed.Items[0].GUID, "first GUID")
+	assert.Equal(t, "urn:example:live:1", parsed.Items[1].GUID, "second GUID")
+	assert.Equal(t, &notPermaLink, parsed.Items[0].GUIDIsPermaLink,
+		"isPermaLink kept")
+	assert.Equal(t, "https://www.example.com/other", parsed.Items[2].GUID,
+		"link if there is no GUID")
+
+	parsed.Update(&feed)
+	assert.Len(t, parsed.Items, 3, "items sharing a link stay distinct")
+}
+
+func TestGUIDIsPermaLink(t *testing.T) {
+	SetGUIDStrategy(GUIDOmit)
+	defer SetGUIDStrategy(GUIDLink)
+
+	yes, no := true, false
+	feed := Feed{
+		Title: "Test feed",
+		Link:  "https://www.example.com/",
+		Items: []Item{
+			{Title: "1", GUID: "https://www.example.com/1", GUIDIsPermaLink: &no},
+			{Title: "2", GUID: "https://www.example.com/2"},
+			{Title: "3", GUID: "/3", GUIDIsPermaLink: &yes},
+		},
+	}
+
+	buf, err := makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(buf),
+		`<guid isPermaLink="false">https://www.example.com/1</guid>`, "false kept")
+	assert.Contains(t, string(buf),
+		`<guid isPermaLink="true">https://www.example.com/2</guid>`,
+		"URL is a permalink when we don't know")
+	assert.Contains(t, string(buf), `<guid isPermaLink="true">/3</guid>`,
+		"true kept")
+
+	parsed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse RSS")
+	require.Len(t, parsed.Items, 3, "item count")
+	assert.Equal(t, &no, parsed.Items[0].GUIDIsPermaLink, "false parsed")
+	assert.Equal(t, &yes, parsed.Items[1].GUIDIsPermaLink, "true parsed")
+
+	parsed, err = ParseFeedXML([]byte(`<rss version="2.0"><channel>
+<item><title>t</title><guid>https://www.example.com/1</guid></item>
+</channel></rss>`))
+	require.NoError(t, err, "parse RSS")
+	require.Len(t, parsed.Items, 1, "item count")
+	assert.Nil(t, parsed.Items[0].GUIDIsPermaLink, "nil when absent")
+}
+
+func TestAutoLastBuildDate(t *testing.T) {
+	feed := Feed{
+		Title:       "Test feed",
+		Link:        "https://www.example.com/",
+		Description: "A nice feed",
+		PubDate:     time.Date(2016, 12, 25, 11, 0, 0, 0, time.UTC),
+		Items: []Item{
+			{Title: "Older", PubDate: time.Date(2016, 12, 24, 0, 0, 0, 0, time.UTC)},
+			{Title: "Newer", PubDate: time.Date(2016, 12, 26, 9, 30, 0, 0, time.UTC)},
+			{Title: "Undated"},
+		},
+	}
+
+	assert.Equal(t, time.Date(2016, 12, 26, 9, 30, 0, 0, time.UTC),
+		feed.LatestItemDate(), "latest item date")
+
+	buf, err := makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(buf),
+		"<lastBuildDate>Sun, 25 Dec 2016 11:00:00 +0000</lastBuildDate>",
+		"feed date by default")
+
+	SetAutoLastBuildDate(true)
+	defer SetAutoLastBuildDate(false)
+
+	buf, err = makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(buf),
+		"<lastBuildDate>Mon, 26 Dec 2016 09:30:00 +0000</lastBuildDate>",
+		"newest item date")
+	assert.Contains(t, string(buf),
+		"<pubDate>Sun, 25 Dec 2016 11:00:00 +0000</pubDate>", "pubDate unchanged")
+
+	feed.Items = []Item{{Title: "Undated"}}
+	buf, err = makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(buf),
+		"<lastBuildDate>Sun, 25 Dec 2016 11:00:00 +0000</lastBuildDate>",
+		"feed date if no item has one")
+}
+
+func TestLastBuildDate(t *testing.T) {
+	feed := Feed{
+		Title:         "Test feed",
+		Link:          "https://www.example.com/",
+		Description:   "A nice feed",
+		PubDate:       time.Date(2016, 12, 25, 11, 0, 0, 0, time.UTC),
+		LastBuildDate: time.Date(2016, 12, 27, 8, 0, 0, 0, time.UTC),
+		Items: []Item{
+			{Title: "Older", PubDate: time.Date(2016, 12, 24, 0, 0, 0, 0, time.UTC)},
+			{Title: "Newer", PubDate: time.Date(2016, 12, 26, 9, 30, 0, 0, time.UTC)},
+		},
+	}
+
+	buf, err := makeXML(feed)
+	require.NoError(t, err, "make XML")
+	out := string(buf)
+	assert.Contains(t, out,
+		"<pubDate>Sun, 25 Dec 2016 11:00:00 +0000</pubDate>", "pubDate")
+	assert.Contains(t, out,
+		"<lastBuildDate>Tue, 27 Dec 2016 08:00:00 +0000</lastBuildDate>",
+		"lastBuildDate")
+	assert.Contains(t, out,
+		"<pubDate>Sat, 24 Dec 2016 00:00:00 +0000</pubDate>", "item pubDate")
+	assert.Contains(t, out,
+		"<pubDate>Mon, 26 Dec 2016 09:30:00 +0000</pubDate>", "item pubDate")
+
+	parsed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse generated RSS")
+	assert.Equal(t, feed.PubDate, parsed.PubDate, "round tripped pubDate")
+	assert.Equal(t, feed.LastBuildDate, parsed.LastBuildDate,
+		"round tripped lastBuildDate")
+
+	SetAutoLastBuildDate(true)
+	defer SetAutoLastBuildDate(false)
+
+	buf, err = makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(buf),
+		"<lastBuildDate>Mon, 26 Dec 2016 09:30:00 +0000</lastBuildDate>",
+		"the setting takes the newest item date")
+
+	feed.Items = nil
+	buf, err = makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(buf),
+		"<lastBuildDate>Tue, 27 Dec 2016 08:00:00 +0000</lastBuildDate>",
+		"lastBuildDate if no item has a date")
+}
+
+func TestIsStale(t *testing.T) {
+	now := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	month := 30 * 24 * time.Hour
+
+	feed := &Feed{
+		Items: []Item{
+			{PubDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{PubDate: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)},
+			{},
+		},
+	}
+	assert.False(t, feed.IsStale(2*month, now), "newest item recent enough")
+	assert.True(t, feed.IsStale(month/2, now), "newest item too old")
+
+	feed.PubDate = time.Date(2020, 6, 30, 0, 0, 0, 0, time.UTC)
+	assert.False(t, feed.IsStale(month/2, now), "feed date is newer")
+
+	assert.False(t, (&Feed{Items: []Item{{}}}).IsStale(month, now),
+		"no dates means unknown")
+}
+
+func TestContentCDATA(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-content-cdata.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 1, "item count")
+
+	// Entities inside CDATA are left alone, and a "]]>" split across two CDATA
+	// sections is joined back together.
+	assert.Equal(t,
+		`<p>Tom &amp; Jerry &lt;3 &#8212; "quotes"</p>
+<script>if (a < b && c > d) { run(); }</script>
+<pre>x[y[0]]> z</pre>`,
+		feed.Items[0].Content, "content")
+	assert.Equal(t, "Summary &amp; more", feed.Items[0].Description,
+		"description unescaped once")
+}
+
+func TestWriteHTML(t *testing.T) {
+	feed := &Feed{
+		Items: []Item{
+			{
+				Title:       "Tom & <Jerry>",
+				Link:        "https://example.com/1?a=1&b=2",
+				Description: "<p>Hi <script>alert(1)</script>there</p>",
+				PubDate:     time.Date(2020, 3, 6, 18, 15, 47, 0, time.UTC),
+			},
+			{
+				Title: "Bad link",
+				Link:  "javascript:alert(1)",
+			},
+			{
+				Title: "No link",
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, feed.WriteHTML(&buf), "write HTML")
+
+	assert.Equal(t, `<ul class="feed">
+  <li class="feed-item"><a class="feed-item-link" href="https://example.com/1?a=1&amp;b=2">Tom &amp; &lt;Jerry&gt;</a> <time class="feed-item-date" datetime="2020-03-06T18:15:47Z">2020-03-06</time>
+    <p class="feed-item-description">Hi there</p>
+  </li>
+  <li class="feed-item"><a class="feed-item-link" href="#ZgotmplZ">Bad link</a>
+  </li>
+  <li class="feed-item"><span class="feed-item-title">No link</span>
+  </li>
+</ul>
+`, buf.String(), "HTML")
+}
+
+func TestEmailDigest(t *testing.T) {
+	feed := &Feed{
+		Title: "News & <Views>",
+		Link:  "https://example.com/",
+		Items: []Item{
+			{
+				Title:       "Old",
+				Link:        "https://example.com/old",
+				Description: "Old news",
+				PubDate:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+				Enclosures: []Enclosure{
+					{URL: "https://example.com/a.mp3", Type: "audio/mpeg"},
+					{URL: "https://example.com/old.jpg", Type: "image/jpeg"},
+				},
+			},
+			{
+				Title:       "Tom & <Jerry>",
+				Link:        "javascript:alert(1)",
+				Description: "<p>Hi <script>alert(1)</script>there</p>",
+				PubDate:     time.Date(2020, 3, 6, 18, 15, 47, 0, time.UTC),
+				ImageURL:    "https://example.com/new.png",
+			},
+		},
+	}
+
+	digest, err := feed.EmailDigest(DigestOptions{MaxItems: 1, IncludeImages: true})
+	require.NoError(t, err, "digest")
+	assert.Equal(t, `<table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0" style="font-family:Arial,Helvetica,sans-serif;color:#222222;">
+  <tr>
+    <td style="padding:0 0 16px 0;font-size:22px;font-weight:bold;"><a href="https://example.com/" style="color:#222222;text-decoration:none;">News &amp; &lt;Views&gt;</a></td>
+  </tr>
+  <tr>
+    <td style="padding:16px 0;border-top:1px solid #dddddd;">
+      <img src="https://example.com/new.png" alt="" style="display:block;max-width:100%;height:auto;border:0;margin:0 0 8px 0;">
+      <div style="font-size:18px;font-weight:bold;"><a href="#ZgotmplZ" style="color:#1a0dab;text-decoration:none;">Tom &amp; &lt;Jerry&gt;</a></div>
+      <div style="padding:4px 0 0 0;font-size:12px;color:#666666;">March 6, 2020</div>
+      <p style="margin:8px 0 0 0;font-size:14px;line-height:20px;">Hi there</p>
+    </td>
+  </tr>
+</table>
+`, digest, "newest item only")
+
+	digest, err = feed.EmailDigest(DigestOptions{IncludeImages: true})
+	require.NoError(t, err, "digest")
+	assert.True(t, strings.Index(digest, "Jerry") < strings.Index(digest, "Old"),
+		"newest first")
+	assert.Contains(t, digest, `<img src="https://example.com/old.jpg"`,
+		"image enclosure")
+	assert.Equal(t, "Old", feed.Items[0].Title, "feed's items not reordered")
+
+	digest, err = feed.EmailDigest(DigestOptions{})
+	require.NoError(t, err, "digest")
+	assert.NotContains(t, digest, "<img", "no images")
+}
+
+func TestExcerpt(t *testing.T) {
+	assert.Equal(t, "Short text", excerpt("<p>Short <b>text</b></p>", 20),
+		"short enough")
+	assert.Equal(t, "The quick brown…",
+		excerpt("The quick brown fox, jumps", 19), "cut at a word")
+	assert.Equal(t, "Thequ…", excerpt("Thequickbrownfox", 8),
+		"no space to cut at")
+	assert.Equal(t, "", excerpt("", 10), "empty")
+
+	text := "Some words, and then some more words"
+	assert.Equal(t, text, excerpt(text, len(text)), "exactly max bytes")
+	for max := 0; max < len(text); max++ {
+		assert.True(t, len(excerpt(text, max)) <= max,
+			"at most %d bytes with the ellipsis", max)
+	}
+}
+
+func TestAtomFractionalSeconds(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/atom-fractional-seconds.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 123000000, time.UTC),
+		feed.PubDate, "feed updated")
+	require.Len(t, feed.Items, 2, "item count")
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 0, 0, 500000000, time.UTC),
+		feed.Items[0].PubDate, "fractional seconds")
+	assert.Equal(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		feed.Items[1].PubDate, "bare Z")
+}
+
+func TestRegisterDateLayout(t *testing.T) {
+	date := "2020.03.06 at 18h15"
+	assert.True(t, parseTime(date).IsZero(), "unknown layout")
+
+	RegisterDateLayout("2006.01.02 at 15h04")
+	defer func() { dateLayouts.layouts = nil }()
+
+	assert.Equal(t, time.Date(2020, 3, 6, 18, 15, 0, 0, time.UTC),
+		parseTime(date), "registered layout")
+	assert.Equal(t, time.Date(2017, 1, 17, 20, 40, 0, 0, time.UTC),
+		parseTime("2017-01-17T20:40:00+00:00"), "built in layouts still work")
+}
+
+func TestParseJSONFeed(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/json-feed.json")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+
+	assert.Equal(t, &Feed{
+		Title:       "JSON blog",
+		Link:        "https://example.com/",
+		Self:        "https://example.com/feed.json",
+		Description: "A JSON Feed",
+		Author:      "Jay Son",
+		Type:        "JSON",
+		FeedType:    FeedJSON,
+		Items: []Item{
+			{
+				Title:       "HTML post",
+				Link:        "https://example.com/2",
+				Description: "<p>Hello</p>",
+				PubDate:     time.Date(2020, 3, 6, 18, 15, 47, 0, time.UTC),
+				PubDateRaw:  "2020-03-06T18:15:47Z",
+				GUID:        "2",
+				Categories:  []Category{{Name: "go"}, {Name: "json"}},
+			},
+			{
+				Title:       "Text post",
+				Link:        "https://example.com/1",
+				Description: "1 &lt; 2",
+				PubDate:     time.Date(2020, 3, 5, 18, 15, 47, 0, time.UTC),
+				PubDateRaw:  "2020-03-05T18:15:47Z",
+				GUID:        "1",
+				Author:      "Old Style",
+			},
+		},
+	}, feed, "feed")
+
+	_, err = ParseFeedXML([]byte(`{"title": "Not a feed"}`))
+	assert.Error(t, err, "JSON without a version")
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		file   string
+		format string
+	}{
+		{"test-data/rss-good.xml", "RSS"},
+		{"test-data/rdf-slashdot.xml", "RDF"},
+		{"test-data/atom-valid.xml", "Atom"},
+		{"test-data/json-feed.json", "JSON"},
+		{"test-data/html-not-a-feed.html", ""},
+	}
+
+	for _, test := range tests {
+		buf, err := ioutil.ReadFile(test.file)
+		require.NoError(t, err, "read file")
+		assert.Equal(t, test.format, DetectFormat(buf), test.file)
+	}
+}
+
+func TestDetectFeedType(t *testing.T) {
+	tests := []struct {
+		input    string
+		feedType string
+		err      string
+	}{
+		{`<?xml version="1.0"?><rss version="2.0"><channel/></rss>`, "RSS", ""},
+		{`<RSS version="2.0"></RSS>`, "RSS", ""},
+		{`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"/>`,
+			"RDF", ""},
+		{`<!-- hi --><feed xmlns="http://www.w3.org/2005/Atom"></feed>`, "Atom",
+			""},
+		{`<feed></feed>`, "", "root element <feed> is not a feed"},
+		{`<feed xmlns="https://example.com/"></feed>`, "",
+			"root element <feed> in namespace https://example.com/ is not a feed"},
+		{`<html><body></body></html>`, "", "root element <html> is not a feed"},
+		{``, "", "document has no root element"},
+		{`<rss`, "", "error decoding token"},
+	}
+
+	for _, test := range tests {
+		feedType, err := DetectFeedType([]byte(test.input))
+		if test.err != "" {
+			require.Error(t, err, test.input)
+			assert.Contains(t, err.Error(), test.err, test.input)
+			continue
+		}
+		require.NoError(t, err, test.input)
+		assert.Equal(t, test.feedType, feedType, test.input)
+	}
+}
+
+func TestFetchFeedJSON(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/json-feed.json")
+	require.NoError(t, err, "read file")
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+			w.Header().Add("Link", `<https://hub.example.com/>; rel="hub"`)
+			_, _ = w.Write(buf)
+		}))
+	defer server.Close()
+
+	feed, err := FetchFeed(context.Background(), server.Client(), server.URL)
+	require.NoError(t, err, "fetch feed")
+	assert.Equal(t, "JSON", feed.Type, "type")
+	assert.Len(t, feed.Items, 2, "item count")
+	assert.Equal(t, "https://example.com/feed.json", feed.Self, "self kept")
+	assert.Equal(t, []string{"https://hub.example.com/"}, feed.Hubs,
+		"hub from Link header")
+
+	// A BOM and a relative item URL get the same handling as any other feed.
+	relative := []byte("\ufeff" + `{
+  "version": "https://jsonfeed.org/version/1.1",
+  "title": "Relative",
+  "feed_url": "https://example.com/feed.json",
+  "items": [{"id": "1", "url": "/posts/1", "content_text": "Hi"}]
+}`)
+	contentType := "application/feed+json"
+	body := relative
+	server2 := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			_, _ = w.Write(body)
+		}))
+	defer server2.Close()
+
+	feed, err = FetchFeed(context.Background(), server2.Client(), server2.URL)
+	require.NoError(t, err, "fetch feed with BOM")
+	assert.Equal(t, "JSON", feed.Type, "type with BOM")
+	require.Len(t, feed.Items, 1, "item count with BOM")
+	assert.Equal(t, server2.URL+"/posts/1", feed.Items[0].Link,
+		"relative item URL resolved against fetched URL")
+
+	// With a generic Content-Type we look at the document instead.
+	contentType = "text/plain"
+	feed, err = FetchFeed(context.Background(), server2.Client(), server2.URL)
+	require.NoError(t, err, "fetch feed with generic Content-Type")
+	assert.Equal(t, "JSON", feed.Type, "type sniffed")
+
+	// A JSON Content-Type means we parse it as JSON Feed, whatever it holds.
+	contentType = "application/json"
+	body = []byte(`<rss><channel><title>XML</title></channel></rss>`)
+	_, err = FetchFeed(context.Background(), server2.Client(), server2.URL)
+	require.Error(t, err, "XML with JSON Content-Type")
+	assert.Contains(t, err.Error(), "JSON Feed", "parsed as JSON Feed")
+}
+
+func TestFetchFeed(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-good.xml")
+	require.NoError(t, err, "read file")
+
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			userAgent = r.Header.Get("User-Agent")
+			switch r.URL.Path {
+			case "/missing":
+				http.NotFound(w, r)
+				return
+			case "/moved":
+				http.Redirect(w, r, "/feeds/relative.xml", http.StatusFound)
+				return
+			case "/feeds/relative.xml":
+				_, _ = w.Write([]byte(`<rss><channel><title>Relative</title>
+<item><title>One</title><link>posts/1</link>
+<enclosure url="audio.mp3" type="audio/mpeg" length="1"/></item>
+</channel></rss>`))
+				return
+			}
+			_, _ = w.Write(buf)
+		}))
+	defer server.Close()
+
+	feed, err := FetchFeed(context.Background(), nil, server.URL)
+	require.NoError(t, err, "fetch feed with default client")
+	assert.Equal(t, "A Nice Site", feed.Title, "title")
+	assert.Contains(t, userAgent, "horgh-rss", "user agent")
+
+	feed, err = FetchFeed(context.Background(), server.Client(),
+		server.URL+"/moved")
+	require.NoError(t, err, "fetch feed with relative links")
+	require.Len(t, feed.Items, 1, "item count")
+	assert.Equal(t, server.URL+"/feeds/posts/1", feed.Items[0].Link,
+		"link resolved against URL after redirect")
+	require.Len(t, feed.Items[0].Enclosures, 1, "enclosure count")
+	assert.Equal(t, server.URL+"/feeds/audio.mp3",
+		feed.Items[0].Enclosures[0].URL, "enclosure resolved")
+
+	_, err = FetchFeed(context.Background(), server.Client(),
+		server.URL+"/missing")
+	require.Error(t, err, "not found")
+	statusErr, ok := err.(*StatusError)
+	require.True(t, ok, "status error")
+	assert.Equal(t, http.StatusNotFound, statusErr.StatusCode, "status code")
+	assert.Equal(t, "unexpected status: 404 Not Found", err.Error(), "message")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = FetchFeed(ctx, server.Client(), server.URL)
+	assert.Error(t, err, "cancelled")
+}
+
+func TestFetchFeedCompressed(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-good.xml")
+	require.NoError(t, err, "read file")
+
+	var gzipped, zlibbed, deflated bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, err = gz.Write(buf)
+	require.NoError(t, err, "gzip")
+	require.NoError(t, gz.Close(), "gzip")
+	zw := zlib.NewWriter(&zlibbed)
+	_, err = zw.Write(buf)
+	require.NoError(t, err, "zlib")
+	require.NoError(t, zw.Close(), "zlib")
+	fw, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	require.NoError(t, err, "flate")
+	_, err = fw.Write(buf)
+	require.NoError(t, err, "flate")
+	require.NoError(t, fw.Close(), "flate")
+
+	// 2 MiB of zeros compresses to a few KiB.
+	var bomb bytes.Buffer
+	gz = gzip.NewWriter(&bomb)
+	_, err = gz.Write(make([]byte, 2*1024*1024))
+	require.NoError(t, err, "gzip")
+	require.NoError(t, gz.Close(), "gzip")
+
+	var acceptEncoding string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			acceptEncoding = r.Header.Get("Accept-Encoding")
+			switch r.URL.Path {
+			case "/gzip":
+				w.Header().Set("Content-Encoding", "gzip")
+				_, _ = w.Write(gzipped.Bytes())
+			case "/zlib":
+				w.Header().Set("Content-Encoding", "deflate")
+				_, _ = w.Write(zlibbed.Bytes())
+			case "/deflate":
+				w.Header().Set("Content-Encoding", "deflate")
+				_, _ = w.Write(deflated.Bytes())
+			case "/bomb":
+				w.Header().Set("Content-Encoding", "gzip")
+				_, _ = w.Write(bomb.Bytes())
+			case "/brotli":
+				w.Header().Set("Content-Encoding", "br")
+				_, _ = w.Write(buf)
+			default:
+				_, _ = w.Write(buf)
+			}
+		}))
+	defer server.Close()
+
+	for _, path := range []string{"/gzip", "/zlib", "/deflate", "/plain"} {
+		feed, err := FetchFeed(context.Background(), server.Client(),
+			server.URL+path)
+		require.NoError(t, err, "fetch %s", path)
+		assert.Equal(t, "A Nice Site", feed.Title, "title from %s", path)
+		assert.Equal(t, "gzip, deflate", acceptEncoding, "accept encoding")
+	}
+
+	_, err = FetchFeed(context.Background(), server.Client(), server.URL+"/brotli")
+	assert.Error(t, err, "unsupported encoding")
+
+	SetMaxFetchBytes(1024 * 1024)
+	defer SetMaxFetchBytes(defaultMaxFetchBytes)
+
+	_, err = FetchFeed(context.Background(), server.Client(), server.URL+"/bomb")
+	require.Error(t, err, "decompressed body too large")
+	assert.Contains(t, err.Error(), "larger than 1048576 bytes", "message")
+}
+
+func TestFeedFetcher(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-good.xml")
+	require.NoError(t, err, "read file")
+
+	etag := `"v1"`
+	var ifNoneMatch, ifModifiedSince string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			ifNoneMatch = r.Header.Get("If-None-Match")
+			ifModifiedSince = r.Header.Get("If-Modified-Since")
+			if ifNoneMatch == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Last-Modified", "Fri, 06 Mar 2020 18:15:47 GMT")
+			_, _ = w.Write(buf)
+		}))
+	defer server.Close()
+
+	fetcher := &FeedFetcher{Client: server.Client()}
+
+	feed, err := fetcher.Fetch(context.Background(), server.URL)
+	require.NoError(t, err, "first fetch")
+	assert.Equal(t, "A Nice Site", feed.Title, "title")
+	assert.Equal(t, "", ifNoneMatch, "unconditional first request")
+
+	_, err = fetcher.Fetch(context.Background(), server.URL)
+	assert.Equal(t, ErrNotModified, err, "not modified")
+	assert.Equal(t, `"v1"`, ifNoneMatch, "If-None-Match sent")
+	assert.Equal(t, "Fri, 06 Mar 2020 18:15:47 GMT", ifModifiedSince,
+		"If-Modified-Since sent")
+
+	state := fetcher.State()
+	assert.Equal(t, map[string]CacheValidators{
+		server.URL: {
+			ETag:         `"v1"`,
+			LastModified: "Fri, 06 Mar 2020 18:15:47 GMT",
+		},
+	}, state, "state")
+
+	restored := &FeedFetcher{Client: server.Client()}
+	restored.SetState(state)
+	_, err = restored.Fetch(context.Background(), server.URL)
+	assert.Equal(t, ErrNotModified, err, "not modified after restoring state")
+
+	etag = `"v2"`
+	feed, err = restored.Fetch(context.Background(), server.URL)
+	require.NoError(t, err, "fetch changed feed")
+	assert.Equal(t, "A Nice Site", feed.Title, "title")
+	assert.Equal(t, `"v2"`, restored.State()[server.URL].ETag, "ETag updated")
+}
+
+func TestClearItems(t *testing.T) {
+	feed := &Feed{
+		Title: "A feed",
+		Link:  "https://example.com/",
+		Items: []Item{{Title: "One"}, {Title: "Two"}},
+	}
+
+	feed.ClearItems()
+	assert.Nil(t, feed.Items, "items removed")
+	assert.Equal(t, "A feed", feed.Title, "title kept")
+	assert.Equal(t, "https://example.com/", feed.Link, "link kept")
+}
+
+func TestCommentsFeedURL(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-wordpress-comments.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 1, "item count")
+	assert.Equal(t, "https://blog.example.com/2020/03/hello-world/feed/",
+		feed.Items[0].CommentsFeedURL, "comment feed URL")
+	assert.Equal(t, 3, feed.Items[0].CommentCount, "comment count")
+}
+
+func TestMakeXMLReproducible(t *testing.T) {
+	feed := Feed{
+		Title:       "Test feed",
+		Link:        "https://www.example.com/",
+		Description: "A nice feed",
+		Categories: []Category{
+			{Name: "Zebra"},
+			{Name: "Apple", Domain: "https://example.com/tags"},
+			{Name: "Mango"},
+		},
+		ITunes:    &ITunesFeed{OwnerName: "Owner", Type: "episodic"},
+		Generator: &Generator{Name: "Gen"},
+		Items:     []Item{{Title: "One", Link: "https://www.example.com/1"}},
+	}
+
+	first, err := makeXML(feed)
+	require.NoError(t, err, "make XML")
+	second, err := makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Equal(t, string(first), string(second), "same output")
+
+	SetCanonicalOrder(true)
+	defer SetCanonicalOrder(false)
+
+	sorted, err := makeXML(feed)
+	require.NoError(t, err, "make XML")
+	feed.Categories = []Category{feed.Categories[2], feed.Categories[1],
+		feed.Categories[0]}
+	reordered, err := makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Equal(t, string(sorted), string(reordered),
+		"category order doesn't matter")
+	assert.Contains(t, string(sorted), `<category>Mango</category>
+    <category>Zebra</category>
+    <category domain="https://example.com/tags">Apple</category>`,
+		"sorted by domain then name")
+}
+
+func TestITunesImages(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-itunes-images.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.NotNil(t, feed.ITunes, "iTunes")
+	assert.Equal(t, "https://podcast.example.com/show.jpg", feed.ITunes.ImageURL,
+		"channel image")
+	require.Len(t, feed.Items, 2, "item count")
+	assert.Equal(t, "https://podcast.example.com/episode-2.jpg",
+		feed.Items[0].ImageURL, "episode image")
+	assert.Equal(t, "https://podcast.example.com/show.jpg",
+		feed.Items[1].ImageURL, "channel image used")
+}
+
+func TestFetchFullFeed(t *testing.T) {
+	page := func(n int, next string) string {
+		link := ""
+		if next != "" {
+			link = `<link rel="next" href="` + next + `"/>`
+		}
+		return fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Page %d</title>
+  <id>urn:example:archive</id>
+  %s
+  <entry>
+    <title>Entry %d</title>
+    <id>urn:example:archive:%d</id>
+    <updated>2020-03-0%dT00:00:00Z</updated>
+  </entry>
+  <entry>
+    <title>Shared</title>
+    <id>urn:example:archive:shared</id>
+    <updated>2020-01-01T00:00:00Z</updated>
+  </entry>
+</feed>`, n, link, n, n, n)
+	}
+
+	pages := map[string]string{
+		"/":      page(3, "/page2"),
+		"/page2": page(2, "page1"),
+		"/page1": page(1, "/"),
+	}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			_, _ = w.Write([]byte(pages[r.URL.Path]))
+		}))
+	defer server.Close()
+
+	feed, err := FetchFullFeed(context.Background(), server.URL+"/")
+	require.NoError(t, err, "fetch full feed")
+	assert.Equal(t, 3, requests, "stopped at page already seen")
+	assert.Equal(t, "Page 3", feed.Title, "first page's metadata")
+	assert.Equal(t, "", feed.NextPageURL, "no next page")
+	var titles []string
+	for _, item := range feed.Items {
+		titles = append(titles, item.Title)
+	}
+	assert.Equal(t, []string{"Entry 3", "Entry 2", "Entry 1", "Shared"}, titles,
+		"merged items")
+
+	SetMaxPages(2)
+	defer SetMaxPages(50)
+
+	requests = 0
+	feed, err = FetchFullFeed(context.Background(), server.URL+"/")
+	require.NoError(t, err, "fetch full feed")
+	assert.Equal(t, 2, requests, "page limit")
+	assert.Len(t, feed.Items, 3, "items from two pages")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = FetchFullFeed(ctx, server.URL+"/")
+	assert.Error(t, err, "cancelled")
+}
+
+func TestRDFItemDates(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rdf-mixed-dates.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, "RDF", feed.Type, "type")
+	require.Len(t, feed.Items, 3, "item count")
+	assert.Equal(t, time.Date(2017, 1, 17, 20, 40, 0, 0, time.UTC),
+		feed.Items[0].PubDate, "dc:date")
+	assert.Equal(t, time.Date(2017, 1, 17, 20, 0, 0, 0, time.UTC),
+		feed.Items[1].PubDate, "pubDate")
+	assert.Equal(t, time.Date(2017, 1, 17, 18, 0, 0, 0, time.UTC),
+		feed.Items[2].PubDate, "dc:date preferred")
+}
+
+func TestRDFItemIdentifiers(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rdf-identifiers.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, "RDF", feed.Type, "type")
+	require.Len(t, feed.Items, 2, "item count")
+
+	assert.Equal(t, "https://example.com/articles/1?utm_source=rss",
+		feed.Items[0].Link, "plain link preferred")
+	assert.Equal(t, "urn:example:article:1", feed.Items[0].GUID,
+		"dc:identifier")
+
+	assert.Equal(t, "https://example.com/articles/2", feed.Items[1].Link,
+		"namespaced link used when plain one is blank")
+	assert.Equal(t, "urn:example:article:2", feed.Items[1].GUID,
+		"dc:identifier")
+}
+
+func TestOnItemError(t *testing.T) {
+	var raws []string
+	var errs []error
+	SetOnItemError(func(raw string, err error) {
+		raws = append(raws, raw)
+		errs = append(errs, err)
+	})
+	defer SetOnItemError(nil)
+
+	feed, err := ParseFeedXML([]byte(`<rss version="2.0"><channel>
+<title>Problems</title>
+<item><title>Fine</title><pubDate>Tue, 17 Jan 2017 20:00:00 +0000</pubDate></item>
+<item><title>Bad date</title><pubDate>yesterday</pubDate></item>
+<item><link>https://example.com/empty</link></item>
+</channel></rss>`))
+	require.NoError(t, err, "parse feed")
+	assert.Len(t, feed.Items, 3, "items still included")
+
+	require.Len(t, errs, 2, "error count")
+	assert.Equal(t, "<title>Bad date</title><pubDate>yesterday</pubDate>",
+		raws[0], "raw item")
+	assert.EqualError(t, errs[0], "unable to parse date [yesterday]",
+		"date error")
+	assert.Equal(t, "<link>https://example.com/empty</link>", raws[1], "raw item")
+	assert.EqualError(t, errs[1], "item has no title or description",
+		"empty item error")
+
+	raws, errs = nil, nil
+	feed, err = ParseFeedXML([]byte(`{
+  "version": "https://jsonfeed.org/version/1.1",
+  "title": "Problems",
+  "items": [
+    {"id": "1", "title": "Fine", "date_published": "2017-01-17T20:00:00Z"},
+    {"id": "2", "title": "Bad date", "date_published": "yesterday"},
+    {"id": "3", "url": "https://example.com/empty"}
+  ]
+}`))
+	require.NoError(t, err, "parse JSON Feed")
+	assert.Len(t, feed.Items, 3, "JSON Feed items still included")
+
+	require.Len(t, errs, 2, "JSON Feed error count")
+	assert.Equal(t,
+		`{"id": "2", "title": "Bad date", "date_published": "yesterday"}`,
+		raws[0], "raw JSON item")
+	assert.EqualError(t, errs[0], "unable to parse date [yesterday]",
+		"JSON Feed date error")
+	assert.Equal(t, `{"id": "3", "url": "https://example.com/empty"}`, raws[1],
+		"raw JSON item")
+	assert.EqualError(t, errs[1], "item has no title or description",
+		"JSON Feed empty item error")
+}
+
+func TestItemAuthorRoundTrip(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-wordpress-comments.xml")
+	require.NoError(t, err, "read file")
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 1, "item count")
+	assert.Equal(t, "admin", feed.Items[0].Author, "dc:creator")
+
+	feed = &Feed{
+		Title: "Authors",
+		Link:  "https://example.com/",
+		Items: []Item{
+			{Title: "One", Link: "https://example.com/1",
+				Author: "john@example.com (John)"},
+			{Title: "Two", Link: "https://example.com/2"},
+		},
+	}
+	out, err := makeXML(*feed)
+	require.NoError(t, err, "make XML")
+	assert.Equal(t, 1, bytes.Count(out, []byte("<author>")), "one author")
+
+	parsed, err := ParseFeedXML(out)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, parsed.Items, 2, "item count")
+	assert.Equal(t, "john@example.com (John)", parsed.Items[0].Author,
+		"author round trips")
+	assert.Equal(t, "", parsed.Items[1].Author, "no author")
+}
+
+func TestMakeAtomXMLLinks(t *testing.T) {
+	feed := Feed{
+		Title: "Test feed",
+		Link:  "https://www.example.com/",
+		Self:  "https://www.example.com/atom.xml",
+		Items: []Item{{Title: "Item", Link: "https://www.example.com/1"}},
+	}
+
+	buf, err := makeAtomXML(feed)
+	require.NoError(t, err, "make Atom XML")
+	assert.Contains(t, string(buf),
+		`<link href="https://www.example.com/" rel="alternate"></link>`,
+		"feed alternate link")
+	assert.Contains(t, string(buf),
+		`<link href="https://www.example.com/atom.xml" rel="self"></link>`,
+		"feed self link")
+	assert.Contains(t, string(buf),
+		`<link href="https://www.example.com/1" rel="alternate"></link>`,
+		"entry alternate link")
+
+	parsed, err := parseAsAtom(context.Background(), buf)
+	require.NoError(t, err, "parse generated Atom")
+	assert.Equal(t, feed.Self, parsed.Self, "self round trips")
+
+	feed.Self = ""
+	buf, err = makeAtomXML(feed)
+	require.NoError(t, err, "make Atom XML")
+	assert.NotContains(t, string(buf), `rel="self"`, "no self link")
+}
+
+func TestMakeAtomXMLSubtitle(t *testing.T) {
+	feed := Feed{
+		Title:       "Test feed",
+		Link:        "https://www.example.com/",
+		Description: "A <em>good</em> feed",
+	}
+
+	buf, err := makeAtomXML(feed)
+	require.NoError(t, err, "make Atom XML")
+	assert.Contains(t, string(buf),
+		`<subtitle type="html">A &lt;em&gt;good&lt;/em&gt; feed</subtitle>`,
+		"html subtitle")
+
+	parsed, err := parseAsAtom(context.Background(), buf)
+	require.NoError(t, err, "parse generated Atom")
+	assert.Equal(t, feed.Description, parsed.Description, "subtitle round trips")
+
+	feed.Description = ""
+	buf, err = makeAtomXML(feed)
+	require.NoError(t, err, "make Atom XML")
+	assert.NotContains(t, string(buf), "subtitle", "no subtitle")
+}
+
+func TestMakeAtomXMLNoDates(t *testing.T) {
+	feed := Feed{
+		Title: "Test feed",
+		Link:  "https://www.example.com/",
+		Items: []Item{{Title: "Item", Link: "https://www.example.com/1"}},
+	}
+
+	buf, err := makeAtomXML(feed)
+	require.NoError(t, err, "make Atom XML")
+	assert.NotContains(t, string(buf), "0001-01-01", "no zero date")
+
+	buf2, err := makeAtomXML(feed)
+	require.NoError(t, err, "make Atom XML again")
+	assert.Equal(t, string(buf), string(buf2), "output is reproducible")
+
+	parsed, err := parseAsAtom(context.Background(), buf)
+	require.NoError(t, err, "parse generated Atom")
+	assert.Equal(t, time.Unix(0, 0).UTC(), parsed.PubDate, "epoch")
+	require.Len(t, parsed.Items, 1, "item count")
+	assert.True(t, parsed.Items[0].PubDate.Equal(parsed.PubDate),
+		"entry inherits feed updated")
+}
+
+func TestReadingTime(t *testing.T) {
+	words := func(n int) string {
+		return "<p>" + strings.Repeat("word ", n) + "</p>"
+	}
+
+	tests := []struct {
+		name  string
+		item  Item
+		wpm   int
+		total time.Duration
+	}{
+		{"empty", Item{}, 0, 0},
+		{"markup only", Item{Description: "<p> </p>"}, 0, 0},
+		{"short", Item{Description: words(10)}, 0, time.Minute},
+		{"rounds down", Item{Description: words(499)}, 0, 2 * time.Minute},
+		{"rounds up", Item{Description: words(500)}, 0, 3 * time.Minute},
+		{"content preferred", Item{Content: words(1000), Description: words(10)},
+			0, 5 * time.Minute},
+		{"custom speed", Item{Description: words(1000)}, 100, 10 * time.Minute},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.total, test.item.ReadingTime(test.wpm), test.name)
+	}
+}
+
+func TestITunesEpisodes(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-itunes-episodes.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, &ITunesFeed{
+		ImageURL: "https://podcast.example.com/show.jpg",
+		Categories: []ITunesCategory{
+			{Name: "Technology", Subcategories: []string{"Podcasting"}},
+			{Name: "Comedy"},
+		},
+	}, feed.ITunes, "channel")
+
+	require.Len(t, feed.Items, 3, "item count")
+	assert.Equal(t, &ITunesItem{
+		Author:   "Jane Host",
+		Duration: time.Hour + 2*time.Minute + 3*time.Second,
+		Episode:  2,
+		Season:   1,
+		Explicit: true,
+	}, feed.Items[0].ITunes, "episode 2")
+	assert.Equal(t, &ITunesItem{Duration: 30 * time.Minute},
+		feed.Items[1].ITunes, "episode 1")
+	assert.Nil(t, feed.Items[2].ITunes, "trailer")
+}
+
+func TestParseITunesDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		duration time.Duration
+	}{
+		{"3600", time.Hour},
+		{"90.5", 90*time.Second + 500*time.Millisecond},
+		{"05:30", 5*time.Minute + 30*time.Second},
+		{"01:00:01", time.Hour + time.Second},
+		{" 2:00 ", 2 * time.Minute},
+		{"", 0},
+		{"abc", 0},
+		{"1:2:3:4", 0},
+		{"-5", 0},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.duration, parseITunesDuration(test.input), test.input)
+	}
+}
+
+func TestEnclosures(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-enclosures.xml")
+	require.NoError(t, err, "read file")
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse RSS")
+	require.Len(t, feed.Items, 1, "item count")
+	enclosures := []Enclosure{
+		{
+			URL:    "https://podcast.example.com/1.mp3",
+			Length: 12345678,
+			Type:   "audio/mpeg",
+		},
+		{
+			URL:  "https://podcast.example.com/1.ogg",
+			Type: "audio/ogg",
+		},
+	}
+	assert.Equal(t, enclosures, feed.Items[0].Enclosures, "RSS enclosures")
+
+	out, err := makeXML(*feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(out),
+		`<enclosure url="https://podcast.example.com/1.mp3" length="12345678" type="audio/mpeg"></enclosure>`,
+		"enclosure written")
+	parsed, err := ParseFeedXML(out)
+	require.NoError(t, err, "parse generated RSS")
+	require.Len(t, parsed.Items, 1, "item count")
+	assert.Equal(t, enclosures, parsed.Items[0].Enclosures, "round trip")
+
+	buf, err = ioutil.ReadFile("test-data/atom-enclosures.xml")
+	require.NoError(t, err, "read file")
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse Atom")
+	require.Len(t, feed.Items, 1, "item count")
+	assert.Equal(t, "https://video.example.com/1", feed.Items[0].Link, "link")
+	atomEnclosures := []Enclosure{
+		{
+			URL:    "https://video.example.com/1.mp4",
+			Length: 987654321,
+			Type:   "video/mp4",
+		},
+	}
+	assert.Equal(t, atomEnclosures, feed.Items[0].Enclosures, "Atom enclosures")
+
+	out, err = makeAtomXML(*feed)
+	require.NoError(t, err, "make Atom XML")
+	assert.Contains(t, string(out),
+		`<link href="https://video.example.com/1.mp4" rel="enclosure" type="video/mp4" length="987654321"></link>`,
+		"enclosure link written")
+	parsed, err = ParseFeedXML(out)
+	require.NoError(t, err, "parse generated Atom")
+	require.Len(t, parsed.Items, 1, "item count")
+	assert.Equal(t, atomEnclosures, parsed.Items[0].Enclosures, "Atom round trip")
+	assert.Equal(t, "https://video.example.com/1", parsed.Items[0].Link,
+		"alternate link kept")
+}
+
+func TestMediaGroups(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-media-group.xml")
+	require.NoError(t, err, "read file")
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse RSS")
+	require.Len(t, feed.Items, 1, "item count")
+
+	assert.Equal(t, []MediaContent{
+		{
+			URL:    "https://video.example.com/launch.jpg",
+			Type:   "image/jpeg",
+			Medium: "image",
+			Width:  640,
+			Height: 360,
+		},
+	}, feed.Items[0].Media, "standalone media")
+
+	assert.Equal(t, [][]MediaContent{
+		{
+			{
+				URL:       "https://video.example.com/launch-1080.mp4",
+				Type:      "video/mp4",
+				Medium:    "video",
+				FileSize:  52428800,
+				Bitrate:   4000,
+				Width:     1920,
+				Height:    1080,
+				Duration:  185 * time.Second,
+				IsDefault: true,
+			},
+			{
+				URL:      "https://video.example.com/launch-480.mp4",
+				Type:     "video/mp4",
+				Medium:   "video",
+				FileSize: 10485760,
+				Bitrate:  800,
+				Width:    854,
+				Height:   480,
+				Duration: 185 * time.Second,
+			},
+		},
+	}, feed.Items[0].MediaGroups, "grouped renditions")
+}
+
+func TestRSSImage(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-image.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	image := &Image{
+		URL:         "https://example.com/logo.png",
+		Title:       "Pictures",
+		Link:        "https://example.com/",
+		Description: "The Pictures logo, a camera",
+	}
+	assert.Equal(t, image, feed.Image, "image")
+
+	out, err := makeXML(*feed)
+	require.NoError(t, err, "make XML")
+	parsed, err := ParseFeedXML(out)
+	require.NoError(t, err, "parse generated RSS")
+	assert.Equal(t, image, parsed.Image, "round trip")
+
+	feed.Image.Description = ""
+	out, err = makeXML(*feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(out), `    <image>
+      <url>https://example.com/logo.png</url>
+      <title>Pictures</title>
+      <link>https://example.com/</link>
+    </image>`, "no empty description")
+	parsed, err = ParseFeedXML(out)
+	require.NoError(t, err, "parse generated RSS")
+	assert.Equal(t, "", parsed.Image.Description, "description stays empty")
+
+	buf, err = ioutil.ReadFile("test-data/rss-good.xml")
+	require.NoError(t, err, "read file")
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Nil(t, feed.Image, "no image")
+}
+
+func TestFeedImage(t *testing.T) {
+	feed, err := ParseFeedXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Pictures</title>
+    <link>https://example.com/</link>
+    <description>A feed with a sized logo</description>
+    <image>
+      <url>https://example.com/logo.png</url>
+      <title>Pictures</title>
+      <link>https://example.com/</link>
+      <width>88</width>
+      <height>31</height>
+    </image>
+  </channel>
+</rss>`))
+	require.NoError(t, err, "parse RSS")
+	image := &Image{
+		URL:    "https://example.com/logo.png",
+		Title:  "Pictures",
+		Link:   "https://example.com/",
+		Width:  88,
+		Height: 31,
+	}
+	assert.Equal(t, image, feed.Image, "RSS image with size")
+
+	out, err := makeXML(*feed)
+	require.NoError(t, err, "make XML")
+	parsed, err := ParseFeedXML(out)
+	require.NoError(t, err, "parse generated RSS")
+	assert.Equal(t, image, parsed.Image, "round trip with size")
+
+	feed, err = ParseFeedXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+  xmlns="http://purl.org/rss/1.0/">
+  <channel rdf:about="https://example.com/">
+    <title>Only a resource</title>
+    <link>https://example.com/</link>
+    <description>The image block is missing</description>
+    <image rdf:resource="https://example.com/logo.gif"/>
+  </channel>
+</rdf:RDF>`))
+	require.NoError(t, err, "parse RDF")
+	assert.Equal(t, &Image{URL: "https://example.com/logo.gif"}, feed.Image,
+		"RDF image from the resource")
+
+	tests := []struct {
+		name  string
+		extra string
+		url   string
+	}{
+		{"logo", `<logo>https://example.com/logo.png</logo>`,
+			"https://example.com/logo.png"},
+		{"icon", `<icon>https://example.com/favicon.ico</icon>`,
+			"https://example.com/favicon.ico"},
+		{"logo preferred",
+			`<icon>https://example.com/favicon.ico</icon><logo>https://example.com/logo.png</logo>`,
+			"https://example.com/logo.png"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			feed, err := ParseFeedXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Atom artwork</title>
+  <link href="https://example.com/"/>
+  <id>urn:example:feed</id>
+  <updated>2020-01-02T03:04:05Z</updated>
+  ` + test.extra + `
+</feed>`))
+			require.NoError(t, err, "parse Atom")
+			require.NotNil(t, feed.Image, "image")
+			assert.Equal(t, test.url, feed.Image.URL, "image URL")
+
+			out, err := makeXML(*feed)
+			require.NoError(t, err, "make XML")
+			parsed, err := ParseFeedXML(out)
+			require.NoError(t, err, "parse generated RSS")
+			assert.Equal(t, &Image{
+				URL:   test.url,
+				Title: "Atom artwork",
+				Link:  "https://example.com/",
+			}, parsed.Image, "RSS image takes the feed's title and link")
+		})
+	}
+
+	feed, err = ParseFeedXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>No artwork</title>
+  <id>urn:example:feed</id>
+  <updated>2020-01-02T03:04:05Z</updated>
+</feed>`))
+	require.NoError(t, err, "parse Atom")
+	assert.Nil(t, feed.Image, "no image")
+}
+
+func TestAllowedSchemes(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-javascript-link.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 3, "item count")
+	assert.Equal(t, "", feed.Items[0].Link, "javascript: link removed")
+	assert.Equal(t, []Enclosure{
+		{URL: "https://example.com/1.mp3", Length: 1234, Type: "audio/mpeg"},
+	}, feed.Items[0].Enclosures, "data: enclosure removed")
+	assert.Equal(t, "https://example.com/2", feed.Items[1].Link, "http kept")
+	assert.Equal(t, "/posts/3?at=12:00", feed.Items[2].Link, "relative kept")
+	assert.Equal(t, []string{
+		"removed link [JavaScript:alert(document.cookie)] of item [Script] as its scheme is not allowed",
+		"removed enclosure [data:audio/mpeg;base64,AAAA] of item [Script] as its scheme is not allowed",
+	}, feed.Warnings, "warnings")
+
+	SetAllowedSchemes(nil)
+	defer SetAllowedSchemes([]string{"http", "https", "mailto"})
+
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 3, "item count")
+	assert.Equal(t, "JavaScript:alert(document.cookie)", feed.Items[0].Link,
+		"all schemes allowed")
+	assert.Len(t, feed.Items[0].Enclosures, 2, "enclosures kept")
+	assert.Nil(t, feed.Warnings, "no warnings")
+}
+
+func TestHybridRSSAtom(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-hybrid-atom.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, "RSS", feed.Type, "type")
+	assert.Equal(t, "https://example.com/", feed.Link, "link from atom:link")
+	assert.Equal(t, "https://example.com/feed.xml", feed.Self, "self")
+	assert.Equal(t, "Ed Itor", feed.Author, "author from atom:author")
+
+	require.Len(t, feed.Items, 2, "item count")
+	assert.Equal(t, "https://example.com/1", feed.Items[0].Link,
+		"link from atom:link")
+	assert.Equal(t, "Wri Ter", feed.Items[0].Author, "author from atom:author")
+	assert.Equal(t, "The short version", feed.Items[0].Summary, "summary")
+	assert.Equal(t, "The short version", feed.Items[0].Description,
+		"description from atom:summary")
+
+	assert.Equal(t, "https://example.com/2", feed.Items[1].Link,
+		"RSS link preferred")
+	assert.Equal(t, "writer@example.com (Wri Ter)", feed.Items[1].Author,
+		"RSS author preferred")
+	assert.Equal(t, "The Atom summary", feed.Items[1].Summary, "summary")
+	assert.Equal(t, "The RSS description", feed.Items[1].Description,
+		"RSS description preferred")
+}
+
+func TestChannelAfterItems(t *testing.T) {
+	tests := []struct {
+		file  string
+		title string
+		items int
+	}{
+		{"test-data/rss-items-before-channel-metadata.xml", "Metadata last", 2},
+		{"test-data/rdf-items-before-channel.xml", "Channel last", 1},
+	}
+
+	SetCaptureExtensions(true)
+	defer SetCaptureExtensions(false)
+
+	for _, test := range tests {
+		buf, err := ioutil.ReadFile(test.file)
+		require.NoError(t, err, "read file")
+
+		feed, err := ParseFeedXML(buf)
+		require.NoError(t, err, test.file)
+		assert.Equal(t, test.title, feed.Title, test.file)
+		assert.Equal(t, "https://example.com/", feed.Link, test.file)
+		assert.Len(t, feed.Items, test.items, test.file)
+		assert.Nil(t, feed.Extensions, test.file)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	feed := Feed{
+		Title:       "Title",
+		Link:        "https://example.com/",
+		Description: "Description",
+		Items: []Item{
+			{Title: "Has a title"},
+			{Description: "Has a description"},
+		},
+	}
+	assert.Empty(t, feed.Validate(), "valid feed")
+
+	feed = Feed{
+		Title: "Title",
+		Items: []Item{{Link: "https://example.com/1"}},
+	}
+	errs := feed.Validate()
+	require.Len(t, errs, 3, "error count")
+	assert.Equal(t, "channel has no link", errs[0].Error(), "link")
+	assert.Equal(t, "channel has no description", errs[1].Error(),
+		"description")
+	assert.Equal(t, "item 0 has no title or description", errs[2].Error(),
+		"item")
+}
+
+func TestStrictRFC(t *testing.T) {
+	SetStrictRFC(true)
+	defer SetStrictRFC(false)
+
+	feed := Feed{
+		Title:       "Title",
+		Link:        "https://example.com/",
+		Description: "Description",
+		PubDate:     time.Date(2019, 3, 4, 5, 6, 7, 0, time.FixedZone("", -7*3600)),
+		Items: []Item{
+			{Title: "One", Link: "https://example.com/1"},
+		},
+	}
+
+	out, err := makeXML(feed)
+	require.NoError(t, err, "valid feed")
+	assert.Contains(t, string(out),
+		"<pubDate>Mon, 04 Mar 2019 12:06:07 GMT</pubDate>", "date in GMT")
+
+	parsed, err := ParseFeedXML(out)
+	require.NoError(t, err, "parse output")
+	assert.True(t, feed.PubDate.Equal(parsed.PubDate), "date round trips")
+
+	feed.Description = ""
+	feed.Items = append(feed.Items, Item{Title: "No link, so no guid"})
+	_, err = makeXML(feed)
+	require.Error(t, err, "invalid feed")
+	assert.Contains(t, err.Error(), "channel has no description", "description")
+	assert.Contains(t, err.Error(), "item 1 has no guid", "guid")
+
+	SetStrictRFC(false)
+	_, err = makeXML(feed)
+	require.NoError(t, err, "not enforced when off")
+}
+
+func TestCheckLinks(t *testing.T) {
+	feed := &Feed{
+		Items: []Item{
+			{Title: "Fine", Link: "https://example.com/1"},
+			{Title: "Mail", Link: "mailto:editor@example.com"},
+			{Title: "Blank", Link: " "},
+			{Title: "Relative", Link: "/posts/2"},
+			{Title: "Malformed", Link: "http://exa mple.com/%zz"},
+			{Title: "No host", Link: "https:///posts/3"},
+		},
+	}
+
+	issues := feed.CheckLinks()
+	require.Len(t, issues, 4, "issue count")
+	assert.Equal(t, LinkIssue{Index: 2, Title: "Blank", Link: " ",
+		Problem: "link is blank"}, issues[0], "blank")
+	assert.Equal(t, LinkIssue{Index: 3, Title: "Relative", Link: "/posts/2",
+		Problem: "link is relative"}, issues[1], "relative")
+	assert.Equal(t, 4, issues[2].Index, "malformed index")
+	assert.Contains(t, issues[2].Problem, "link is malformed", "malformed")
+	assert.Equal(t, LinkIssue{Index: 5, Title: "No host",
+		Link: "https:///posts/3", Problem: "link has no host"}, issues[3],
+		"no host")
+
+	feed.Items = feed.Items[:2]
+	assert.Equal(t, []LinkIssue{}, feed.CheckLinks(), "clean feed")
+}
+
+func TestMarshalJSONFeed(t *testing.T) {
+	feed := Feed{
+		Title:       "Test feed",
+		Link:        "https://www.example.com/",
+		Self:        "https://www.example.com/feed.json",
+		Description: "A nice feed",
+		Author:      "Ed Itor",
+		Items: []Item{
+			{
+				Title:       "Nice item 1",
+				Link:        "https://www.example.com/1",
+				Description: "<p>Item 1 is very nice</p>",
+				PubDate:     time.Date(2016, 12, 25, 11, 1, 0, 0, time.UTC),
+				GUID:        "tag:example.com,2016:1",
+				Categories:  []Category{{Name: "go"}},
+				Enclosures: []Enclosure{{URL: "https://www.example.com/1.mp3",
+					Type: "audio/mpeg", Length: 1234}},
+			},
+			{
+				Title: "Nice item 2",
+				Link:  "https://www.example.com/2",
+			},
+		},
+	}
+
+	buf, err := feed.MarshalJSONFeed()
+	require.NoError(t, err, "marshal")
+	assert.Contains(t, string(buf),
+		`"version": "https://jsonfeed.org/version/1.1"`, "version")
+	assert.Contains(t, string(buf),
+		`"content_html": "<p>Item 1 is very nice</p>"`, "HTML not escaped")
+	assert.Contains(t, string(buf),
+		`"date_published": "2016-12-25T11:01:00Z"`, "date")
+	assert.Contains(t, string(buf), `"mime_type": "audio/mpeg"`, "attachment")
+
+	parsed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse generated JSON Feed")
+	assert.Equal(t, "JSON", parsed.Type, "type")
+	assert.Equal(t, feed.Title, parsed.Title, "title")
+	assert.Equal(t, feed.Link, parsed.Link, "home page")
+	assert.Equal(t, feed.Self, parsed.Self, "feed URL")
+	assert.Equal(t, feed.Description, parsed.Description, "description")
+	assert.Equal(t, feed.Author, parsed.Author, "author")
+	require.Len(t, parsed.Items, 2, "item count")
+	assert.Equal(t, "tag:example.com,2016:1", parsed.Items[0].GUID, "GUID as id")
+	assert.Equal(t, feed.Items[0].PubDate, parsed.Items[0].PubDate, "date")
+	assert.Equal(t, feed.Items[0].Description, parsed.Items[0].Description,
+		"content")
+	assert.Equal(t, feed.Items[0].Categories, parsed.Items[0].Categories, "tags")
+	assert.Equal(t, "https://www.example.com/2", parsed.Items[1].GUID,
+		"link as id")
+
+	buf, err = Feed{Title: "Empty"}.MarshalJSONFeed()
+	require.NoError(t, err, "marshal empty feed")
+	assert.Contains(t, string(buf), `"items": []`, "items is an array")
+}
+
+func TestCopyright(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-item-rights.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, "Copyright 2020 Example News", feed.Copyright,
+		"channel copyright")
+	require.Len(t, feed.Items, 2, "item count")
+	assert.Equal(t, "Copyright 2020 Example News", feed.Items[0].Copyright,
+		"item without rights takes the feed's")
+	assert.Equal(t, "Copyright 2020 Agency Press. Used with permission.",
+		feed.Items[1].Copyright, "item rights")
+
+	feed, err = ParseFeedXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Atom rights</title>
+  <id>urn:example:feed</id>
+  <updated>2020-01-02T03:04:05Z</updated>
+  <rights>Copyright 2020 Example</rights>
+  <entry>
+    <title>One</title>
+    <id>urn:example:1</id>
+    <updated>2020-01-02T03:04:05Z</updated>
+    <rights type="text">CC BY 4.0</rights>
+  </entry>
+</feed>`))
+	require.NoError(t, err, "parse Atom feed")
+	assert.Equal(t, "Copyright 2020 Example", feed.Copyright, "Atom feed rights")
+	assert.Equal(t, "CC BY 4.0", feed.Items[0].Copyright, "Atom entry rights")
+
+	buf, err = ioutil.ReadFile("test-data/rss-image.xml")
+	require.NoError(t, err, "read file")
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, "", feed.Copyright, "no channel copyright")
+	assert.Equal(t, "", feed.Items[0].Copyright, "no item copyright")
+}
+
+func TestNormalizePercentEncoding(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"", ""},
+		{"https://example.com/a/b", "https://example.com/a/b"},
+		{"https://example.com/%7Euser", "https://example.com/~user"},
+		{"%41%62%30%2D%2E%5F%7e", "Ab0-._~"},
+		{"https://example.com/a%2fb", "https://example.com/a%2Fb"},
+		{"https://example.com/?q=a%26b", "https://example.com/?q=a%26b"},
+		{"caf%c3%a9", "caf%C3%A9"},
+		{"100%", "100%"},
+		{"%7", "%7"},
+		{"%zz%7E", "%zz~"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.output, normalizePercentEncoding(test.input),
+			"normalize %q", test.input)
+	}
+}
+
+func TestNormalizePercentEncodingSetting(t *testing.T) {
+	buf1, err := ioutil.ReadFile("test-data/rss-guid-encoding-1.xml")
+	require.NoError(t, err, "read file")
+	buf2, err := ioutil.ReadFile("test-data/rss-guid-encoding-2.xml")
+	require.NoError(t, err, "read file")
+
+	feed1, err := ParseFeedXML(buf1)
+	require.NoError(t, err, "parse first feed")
+	feed2, err := ParseFeedXML(buf2)
+	require.NoError(t, err, "parse second feed")
+	feed1.Update(feed2)
+	assert.Len(t, feed1.Items, 4, "without the setting the items look new")
+
+	SetNormalizePercentEncoding(true)
+	defer SetNormalizePercentEncoding(false)
+
+	feed1, err = ParseFeedXML(buf1)
+	require.NoError(t, err, "parse first feed")
+	feed2, err = ParseFeedXML(buf2)
+	require.NoError(t, err, "parse second feed")
+
+	assert.Equal(t, "https://example.com/~blog/", feed1.Link, "feed link")
+	assert.Equal(t, feed1.Link, feed2.Link, "feed links match")
+	for i := range feed1.Items {
+		assert.Equal(t, feed1.Items[i].GUID, feed2.Items[i].GUID, "GUID %d", i)
+		assert.Equal(t, feed1.Items[i].Link, feed2.Items[i].Link, "link %d", i)
+	}
+	assert.Equal(t, "tag:example.com,2020:~blog/a%2Fb", feed1.Items[1].GUID,
+		"escaped slash stays escaped")
+
+	feed1.Update(feed2)
+	assert.Len(t, feed1.Items, 2, "the same items")
+}
+
+func TestItemTitleMarkup(t *testing.T) {
+	tests := []struct {
+		file   string
+		titles []string
+	}{
+		{"test-data/rss-title-markup.xml", []string{"Hi", "Hi", "Tom & Jerry"}},
+		{"test-data/rdf-title-markup.xml", []string{"Hi", "Hi", "Tom & Jerry"}},
+		{"test-data/atom-title-markup.xml",
+			[]string{"Hi", "Hi", "Tom & Jerry", "<b> is bold"}},
+		{"test-data/json-title-markup.json",
+			[]string{"Hi", "Hi", "Tom & Jerry", "<b> is bold"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			buf, err := ioutil.ReadFile(test.file)
+			require.NoError(t, err, "read file")
+
+			feed, err := ParseFeedXML(buf)
+			require.NoError(t, err, "parse feed")
+
+			var titles []string
+			for _, item := range feed.Items {
+				titles = append(titles, item.Title)
+			}
+			assert.Equal(t, test.titles, titles, "titles")
+		})
+	}
+}
+
+// cancelAfterContext is a context that becomes canceled after its Err()
+// method has been called a number of times. This lets us cancel part way
+// through parsing without depending on timing.
+type cancelAfterContext struct {
+	context.Context
+	checks int
+}
+
+func (c *cancelAfterContext) Err() error {
+	if c.checks <= 0 {
+		return context.Canceled
+	}
+	c.checks--
+	return nil
+}
+
+func TestParseFeedXMLContext(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Large</title>
+    <link>https://example.com/</link>
+    <description>A large feed</description>
+`)
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&b, `    <item>
+      <title>Item %d</title>
+      <link>https://example.com/%d</link>
+      <description>Some text about item %d</description>
+    </item>
+`, i, i, i)
+	}
+	b.WriteString("  </channel>\n</rss>\n")
+	data := []byte(b.String())
+
+	feed, err := ParseFeedXMLContext(context.Background(), data)
+	require.NoError(t, err, "parse with background context")
+	assert.Len(t, feed.Items, 2000, "item count")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	feed, err = ParseFeedXMLContext(ctx, data)
+	require.NoError(t, err, "parse with context not done")
+	assert.Len(t, feed.Items, 2000, "item count")
+
+	checks := &cancelAfterContext{Context: ctx, checks: 10}
+	feed, err = ParseFeedXMLContext(checks, data)
+	assert.Equal(t, context.Canceled, err, "canceled mid-parse")
+	assert.Nil(t, feed, "no feed")
+	assert.Equal(t, 0, checks.checks, "checked until canceled")
+
+	expired, cancelExpired := context.WithDeadline(context.Background(),
+		time.Now().Add(-time.Second))
+	defer cancelExpired()
+	_, err = ParseFeedXMLContext(expired, data)
+	assert.Equal(t, context.DeadlineExceeded, err, "deadline exceeded")
+
+	SetScrape(true)
+	defer SetScrape(false)
+	_, err = ParseFeedXMLContext(&cancelAfterContext{Context: ctx, checks: 10},
+		data)
+	assert.Equal(t, context.Canceled, err, "no scraping once canceled")
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"", ""},
+		{"Plain & simple", "Plain &amp; simple"},
+		{`<p>Hi <strong>there</strong></p>`, `<p>Hi <strong>there</strong></p>`},
+		{`<p onclick="evil()" class="x">Hi</p>`, `<p>Hi</p>`},
+		{`Before<script>alert("x")</script>after`, `Beforeafter`},
+		{`<style>p { color: red }</style><p>Hi</p>`, `<p>Hi</p>`},
+		{`<div><span>Kept text</span></div>`, `Kept text`},
+		{`<a href="https://example.com/?a=1&amp;b=2" target="_blank">Link</a>`,
+			`<a href="https://example.com/?a=1&amp;b=2">Link</a>`},
+		{`<a href="javascript:alert(1)">Bad</a>`, `<a>Bad</a>`},
+		{`<a href=" JavaScript:alert(1)">Bad</a>`, `<a>Bad</a>`},
+		{`<a href="mailto:me@example.com">Mail</a>`,
+			`<a href="mailto:me@example.com">Mail</a>`},
+		{`<a href="/relative">Rel</a>`, `<a href="/relative">Rel</a>`},
+		{`<img src="https://example.com/a.png" alt="A" onerror="evil()">`,
+			`<img src="https://example.com/a.png" alt="A">`},
+		{`<img src="data:image/png;base64,AAAA">`, `<img>`},
+		{`<img src="https://t.example.com/p.gif" width="1" height="1">`, ``},
+		{`<img src="https://t.example.com/p.gif" width="1px" height="1px"/>`, ``},
+		{`<ul><li>One<li>Two</ul>`, `<ul><li>One</li><li>Two</li></ul>`},
+		{`<p>One<p>Two`, `<p>One</p><p>Two</p>`},
+		{`<p><em>Unclosed`, `<p><em>Unclosed</em></p>`},
+		{`Stray</p> end`, `Stray end`},
+		{`<blockquote><code>x &lt; y</code></blockquote>`,
+			`<blockquote><code>x &lt; y</code></blockquote>`},
+		{`<iframe src="https://example.com/"></iframe>Text`, `Text`},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.output, sanitizeHTML(test.input),
+			"sanitize %q", test.input)
+	}
+}
+
+func TestSanitizeHTMLSetting(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+  <channel>
+    <title>Unsafe</title>
+    <link>https://example.com/</link>
+    <description><![CDATA[About <script>evil()</script>us]]></description>
+    <item>
+      <title>Item</title>
+      <link>https://example.com/1</link>
+      <description><![CDATA[<p onmouseover="evil()">Hi</p>]]></description>
+      <content:encoded><![CDATA[<p>Full<img src="https://t.example.com/p.gif" width="1" height="1"></p>]]></content:encoded>
+    </item>
+  </channel>
+</rss>`)
+
+	feed, err := ParseFeedXML(data)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, `About <script>evil()</script>us`, feed.Description,
+		"left alone by default")
+
+	SetSanitizeHTML(true)
+	defer SetSanitizeHTML(false)
+
+	feed, err = ParseFeedXML(data)
+	require.NoError(t, err, "parse feed")
+	assert.Equal(t, "About us", feed.Description, "feed description")
+	require.Len(t, feed.Items, 1, "item count")
+	assert.Equal(t, "<p>Hi</p>", feed.Items[0].Description, "item description")
+	assert.Equal(t, "<p>Full</p>", feed.Items[0].Content, "item content")
+}
+
+func TestResolveRelativeURLs(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/atom-xml-base.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse Atom feed")
+	assert.Equal(t, "https://example.com/blog/", feed.Link, "feed link")
+	assert.Equal(t, "https://example.com/blog/feed.atom", feed.Self, "self link")
+	require.Len(t, feed.Items, 3, "item count")
+	assert.Equal(t, "https://example.com/blog/post/1", feed.Items[0].Link,
+		"relative to the feed's xml:base")
+	assert.Equal(t, "https://example.com/media/1.mp3",
+		feed.Items[0].Enclosures[0].URL, "enclosure")
+	assert.Equal(t, "https://example.com/blog/archive/2", feed.Items[1].Link,
+		"relative to the entry's xml:base")
+	assert.Equal(t, "https://other.example.com/3", feed.Items[2].Link,
+		"absolute link kept")
+
+	buf, err = ioutil.ReadFile("test-data/rss-relative-links.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse RSS feed")
+	assert.Equal(t, "https://example.com/", feed.Link, "feed link")
+	require.Len(t, feed.Items, 3, "item count")
+	assert.Equal(t, "https://example.com/posts/1", feed.Items[0].Link,
+		"relative to the self link")
+	assert.Equal(t, "https://example.com/media/1.mp3",
+		feed.Items[0].Enclosures[0].URL, "enclosure")
+	assert.Equal(t, "https://other.example.com/2", feed.Items[1].Link,
+		"absolute link kept")
+	assert.Equal(t, "", feed.Items[2].Link, "blank link stays blank")
+
+	feed, err = ParseFeedXMLWithBase(buf, "https://mirror.example.net/feeds/a.xml")
+	require.NoError(t, err, "parse RSS feed with base")
+	assert.Equal(t, "https://mirror.example.net/posts/1", feed.Items[0].Link,
+		"relative to the given base")
+	assert.Equal(t, "https://mirror.example.net/feeds/media/1.mp3",
+		feed.Items[0].Enclosures[0].URL, "enclosure relative to the given base")
+	assert.Equal(t, "https://example.com/feed.xml", feed.Self,
+		"absolute self link kept")
+
+	feed, err = ParseFeedXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>No base</title>
+    <link>/</link>
+    <description>Nothing to resolve against</description>
+    <item>
+      <title>Relative</title>
+      <link>/posts/1</link>
+    </item>
+  </channel>
+</rss>`))
+	require.NoError(t, err, "parse RSS feed without a base")
+	assert.Equal(t, "/posts/1", feed.Items[0].Link, "left relative")
+}
+
+func TestParseFeedStream(t *testing.T) {
+	files := []string{
+		"test-data/rss-good.xml",
+		"test-data/rss-itunes.xml",
+		"test-data/rss-media-group.xml",
+		"test-data/rss-relative-links.xml",
+		"test-data/rdf-slashdot.xml",
+		"test-data/atom-valid.xml",
+		"test-data/atom-xml-base.xml",
+	}
+
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			buf, err := ioutil.ReadFile(file)
+			require.NoError(t, err, "read file")
+
+			want, err := ParseFeedXML(buf)
+			require.NoError(t, err, "parse feed")
+
+			var items []Item
+			feed, err := ParseFeedStream(bytes.NewReader(buf), func(item Item) error {
+				items = append(items, item)
+				return nil
+			})
+			require.NoError(t, err, "stream feed")
+
+			assert.Equal(t, want.Items, items, "items")
+			want.Items = nil
+			assert.Equal(t, want, feed, "metadata")
+		})
+	}
+}
+
+func TestParseFeedStreamStop(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-good.xml")
+	require.NoError(t, err, "read file")
+
+	var titles []string
+	feed, err := ParseFeedStream(bytes.NewReader(buf), func(item Item) error {
+		titles = append(titles, item.Title)
+		return ErrStopStream
+	})
+	require.NoError(t, err, "stopping is not an error")
+	assert.Len(t, titles, 1, "stopped after the first item")
+	assert.NotEmpty(t, feed.Title, "metadata before the first item")
+
+	errCallback := fmt.Errorf("callback failed")
+	feed, err = ParseFeedStream(bytes.NewReader(buf), func(item Item) error {
+		return errCallback
+	})
+	assert.Equal(t, errCallback, err, "callback error returned")
+	assert.Nil(t, feed, "no feed on error")
+
+	_, err = ParseFeedStream(strings.NewReader(`<html><body></body></html>`),
+		func(item Item) error { return nil })
+	assert.Error(t, err, "not a feed")
+
+	_, err = ParseFeedStream(strings.NewReader(`<rss><channel><item><title>`),
+		func(item Item) error { return nil })
+	assert.Error(t, err, "truncated feed")
+}
+
+func TestFeedTypeString(t *testing.T) {
+	for _, feedType := range []FeedType{FeedRSS, FeedRDF, FeedAtom, FeedJSON,
+		FeedHTML} {
+		assert.NotEqual(t, "Unknown", feedType.String(), "named")
+	}
+	assert.Equal(t, "Atom", FeedAtom.String(), "matches Type")
+	assert.Equal(t, "Unknown", FeedUnknown.String(), "unknown")
+	assert.Equal(t, "Unknown", FeedType(100).String(), "out of range")
+	assert.Equal(t, "feed is RSS", fmt.Sprintf("feed is %s", FeedRSS),
+		"formats with String")
+}
+
+func TestDescriptionForms(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-description-forms.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse feed")
+	require.Len(t, feed.Items, 2, "item count")
+
+	want := `<p>Tom &amp; Jerry <a href="https://example.com/?a=1&amp;b=2">watch</a></p>`
+	assert.Equal(t, want, feed.Items[0].Description, "CDATA")
+	assert.Equal(t, want, feed.Items[1].Description, "escaped")
+}
+
+func TestLanguage(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		language string
+	}{
+		{
+			"RSS",
+			`<rss version="2.0"><channel><title>t</title><link>l</link>
+<description>d</description><language> fr-ca </language></channel></rss>`,
+			"fr-ca",
+		},
+		{
+			"RSS with dc:language",
+			`<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/"><channel>
+<title>t</title><link>l</link><description>d</description>
+<dc:language>de</dc:language></channel></rss>`,
+			"de",
+		},
+		{
+			"RDF",
+			`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+xmlns="http://purl.org/rss/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel><title>t</title><link>l</link><description>d</description>
+<dc:language>ja</dc:language></channel></rdf:RDF>`,
+			"ja",
+		},
+		{
+			"Atom",
+			`<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="es"><title>t</title>
+<id>urn:example:lang</id><updated>2020-01-01T00:00:00Z</updated></feed>`,
+			"es",
+		},
+		{
+			"absent",
+			`<rss version="2.0"><channel><title>t</title><link>l</link>
+<description>d</description></channel></rss>`,
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			feed, err := ParseFeedXML([]byte(test.input))
+			require.NoError(t, err, "parse feed")
+			assert.Equal(t, test.language, feed.Language, "language")
+		})
+	}
+
+	feed := Feed{Title: "t", Link: "l", Description: "d", Language: "en-gb"}
+	buf, err := makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(buf), "<language>en-gb</language>", "written")
+
+	parsed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse written feed")
+	assert.Equal(t, "en-gb", parsed.Language, "round trip")
+
+	feed.Language = ""
+	buf, err = makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.NotContains(t, string(buf), "<language>", "omitted when blank")
+}
+
+func TestWriteSelfLink(t *testing.T) {
+	feed := Feed{
+		Title:       "Nice title",
+		Link:        "https://example.com/",
+		Description: "Nice description",
+		Self:        "https://example.com/feed.xml",
+	}
+
+	buf, err := makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(buf),
+		`<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">`,
+		"namespace declared")
+	assert.Contains(t, string(buf),
+		`<atom:link href="https://example.com/feed.xml" rel="self" type="application/rss+xml"></atom:link>`,
+		"self link")
+
+	parsed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse written feed")
+	assert.Equal(t, feed.Self, parsed.Self, "round trip")
+
+	feed.Self = ""
+	buf, err = makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.NotContains(t, string(buf), "atom", "no link or namespace without Self")
+}
+
+func TestWriteContentEncoded(t *testing.T) {
+	feed := Feed{
+		Title:       "Full text",
+		Link:        "https://example.com/",
+		Description: "Full text feed",
+		Items: []Item{
+			{
+				Title:       "Rich post",
+				Link:        "https://example.com/rich",
+				Description: "The short version",
+				Content:     `<p>Tom &amp; Jerry</p><pre>x[y[0]]]]></pre>`,
+			},
+			{
+				Title:       "Plain post",
+				Link:        "https://example.com/plain",
+				Description: "Only a description",
+			},
+		},
+	}
+
+	buf, err := makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.Contains(t, string(buf),
+		`xmlns:content="http://purl.org/rss/1.0/modules/content/"`,
+		"namespace declared")
+	assert.Contains(t, string(buf),
+		`<content:encoded><![CDATA[<p>Tom &amp; Jerry</p>`, "content in CDATA")
+	assert.Equal(t, 1, strings.Count(string(buf), "<content:encoded>"),
+		"only items with content")
+
+	parsed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse written feed")
+	require.Len(t, parsed.Items, 2, "item count")
+	assert.Equal(t, feed.Items[0].Content, parsed.Items[0].Content,
+		"content round trips")
+	assert.Equal(t, "The short version", parsed.Items[0].Description,
+		"description kept")
+	assert.Equal(t, "", parsed.Items[1].Content, "no content")
+
+	feed.Items = feed.Items[1:]
+	buf, err = makeXML(feed)
+	require.NoError(t, err, "make XML")
+	assert.NotContains(t, string(buf), "content", "no namespace without content")
+}
+
+func TestLenientMalformedItems(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/rss-malformed-item.xml")
+	require.NoError(t, err, "read file")
+
+	_, err = ParseFeedXML(buf)
+	assert.Error(t, err, "malformed by default")
+
+	SetLenient(true)
+	defer SetLenient(false)
+
+	feed, err := ParseFeedXML(buf)
+	require.NoError(t, err, "parse leniently")
+	assert.Equal(t, "Mostly fine", feed.Title, "title")
+	assert.Equal(t, FeedRSS, feed.FeedType, "type")
+	require.Len(t, feed.Items, 2, "good items kept")
+	assert.Equal(t, "First", feed.Items[0].Title, "first item")
+	assert.Equal(t, "Alice", feed.Items[0].Author, "namespaced element")
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		feed.Items[0].PubDate, "date")
+	assert.Equal(t, "Fourth", feed.Items[1].Title, "fourth item")
+	assert.True(t, feed.Items[1].PubDate.IsZero(), "bad date kept as zero")
+
+	require.Len(t, feed.Warnings, 4, "warnings")
+	assert.Contains(t, feed.Warnings[0], "not well-formed", "why")
+	assert.Contains(t, feed.Warnings[1], "skipped malformed item 1",
+		"mismatched tag")
+	assert.Contains(t, feed.Warnings[2], "skipped malformed item 2",
+		"undefined entity")
+	assert.Contains(t, feed.Warnings[3], "not a date", "bad date")
+
+	buf, err = ioutil.ReadFile("test-data/atom-malformed-entry.xml")
+	require.NoError(t, err, "read file")
+
+	feed, err = ParseFeedXML(buf)
+	require.NoError(t, err, "parse Atom leniently")
+	require.Len(t, feed.Items, 1, "good entry kept")
+	assert.Equal(t, "Fine", feed.Items[0].Title, "entry")
+	assert.Equal(t, "https://example.com/2", feed.Items[0].Link, "link")
+
+	_, err = ParseFeedXML([]byte(`<rss><channel><title>Bad</titel>
+<item><title>Fine</title></item></channel></rss>`))
+	assert.Error(t, err, "malformed metadata")
+
+	feed, err = ParseFeedXML([]byte(`<rss><channel><title>Cut off</title>
+<item><title>Fine</title></item>
+<item><title>Truncated</title>
+</channel></rss>`))
+	require.NoError(t, err, "parse with truncated last item")
+	assert.Equal(t, "Cut off", feed.Title, "title with truncated last item")
+	require.Len(t, feed.Items, 1, "truncated item skipped")
+	assert.Equal(t, "Fine", feed.Items[0].Title, "item before truncated one")
+
+	feed, err = ParseFeedXML([]byte(`<rss><channel><title>CDATA</title>
+<description><![CDATA[Use <item> tags]]></description>
+<item><title>Fine</title></item>
+<item><title>Bad</titel></item>
+</channel></rss>`))
+	require.NoError(t, err, "parse with tag in CDATA")
+	assert.Equal(t, "Use <item> tags", feed.Description, "CDATA kept")
+	require.Len(t, feed.Items, 1, "item in CDATA ignored")
+	assert.Equal(t, "Fine", feed.Items[0].Title, "item after CDATA")
+
+	feed, err = ParseFeedXML([]byte(`<rdf:RDF
+xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+xmlns:rss="http://purl.org/rss/1.0/">
+<rss:channel><rss:title>Prefixed</rss:title></rss:channel>
+<rss:item><rss:title>Fine</rss:title></rss:item>
+<rss:item><rss:title>Bad</rss:titel></rss:item>
+</rdf:RDF>`))
+	require.NoError(t, err, "parse with prefixed items")
+	require.Len(t, feed.Items, 1, "prefixed item found")
+	assert.Equal(t, "Fine", feed.Items[0].Title, "prefixed item")
+}
+
+func TestDiscoverFeeds(t *testing.T) {
+	buf, err := ioutil.ReadFile("test-data/html-feed-links.html")
+	require.NoError(t, err, "read file")
+
+	feeds, err := DiscoverFeeds(buf, "https://blog.example.com/")
+	require.NoError(t, err, "discover feeds")
+	assert.Equal(t, []string{
+		"https://blog.example.com/en/feed.xml",
+		"https://blog.example.com/atom.xml",
+		"https://feeds.example.net/blog.json",
+	}, feeds, "feeds resolved against <base>, in order, without duplicates")
+
+	feeds, err = DiscoverFeeds([]byte(`<html><head>
+<link rel="alternate" type="application/rss+xml" href="../feed/">
+</head></html>`), "https://example.com/blog/post/")
+	require.NoError(t, err, "discover feeds without <base>")
+	assert.Equal(t, []string{"https://example.com/blog/feed/"}, feeds,
+		"resolved against the base URL")
+
+	feeds, err = DiscoverFeeds([]byte(`<html><body>No feeds</body></html>`),
+		"https://example.com/")
+	require.NoError(t, err, "no feeds is not an error")
+	assert.Empty(t, feeds, "no feeds")
+
+	_, err = DiscoverFeeds(buf, "/relative")
+	assert.Error(t, err, "base URL must be absolute")
+}
@@ -0,0 +1,222 @@
+package rss
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+
+	"github.com/pkg/errors"
+)
+
+// salvageFeed parses a feed that isn't well-formed XML by skipping the items
+// we can't decode. See the Lenient setting.
+//
+// The XML decoder can't continue past a syntax error, so we find the items by
+// looking for their tags in the raw bytes. We decode the document with the
+// items cut out to get the feed's metadata. Then we decode each item on its
+// own, wrapped in the document's prolog and root element so namespace
+// prefixes still mean the same thing.
+func salvageFeed(ctx context.Context, data []byte) (*Feed, error) {
+	feedType, err := DetectFeedType(data)
+	if err != nil {
+		return nil, err
+	}
+
+	prolog, rootName, err := feedProlog(data)
+	if err != nil {
+		return nil, err
+	}
+
+	spans := itemSpans(data, len(prolog), itemElement(feedType), rootName)
+	if len(spans) == 0 {
+		return nil, errors.New("no items to skip")
+	}
+
+	// Cut the items out. What's left is the feed's metadata.
+	var skeleton []byte
+	prev := 0
+	for _, span := range spans {
+		skeleton = append(skeleton, data[prev:span.start]...)
+		prev = span.end
+	}
+	skeleton = append(skeleton, data[prev:]...)
+
+	doc, newFeed, decodeItem := formatDecoders(feedType)
+	if err := newDecoder(ctx, skeleton).Decode(doc); err != nil {
+		return nil, fmt.Errorf("%s XML decode error: %v", feedType, err)
+	}
+	feed := newFeed()
+
+	feed.Warnings = append(feed.Warnings,
+		"document is not well-formed XML, so we skipped items we couldn't parse")
+
+	closing := []byte("</" + rootName + ">")
+	for i, span := range spans {
+		var itemDoc []byte
+		itemDoc = append(itemDoc, prolog...)
+		itemDoc = append(itemDoc, data[span.start:span.end]...)
+		itemDoc = append(itemDoc, closing...)
+
+		item, err := salvageItem(ctx, feed, itemDoc, decodeItem)
+		if err != nil {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			feed.Warnings = append(feed.Warnings,
+				fmt.Sprintf("skipped malformed item %d: %s", i, err))
+			continue
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	if config.Verbose {
+		log.Printf("Salvaged channel as %s [%s]", feedType, feed.Title)
+	}
+
+	finishFeed(feed)
+
+	return feed, nil
+}
+
+// salvageItem decodes the first item in doc, a document holding only the one
+// item.
+func salvageItem(ctx context.Context, feed *Feed, doc []byte,
+	decodeItem itemDecoder) (Item, error) {
+	d := newDecoder(ctx, doc)
+	if _, err := rootElement(d); err != nil {
+		return Item{}, err
+	}
+
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return Item{}, errors.Wrap(err, "error decoding token")
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return decodeItem(feed, d, &start)
+		}
+	}
+}
+
+// feedProlog returns the start of the document up to and including the root
+// element's start tag, and the root element's name as written (including any
+// prefix).
+func feedProlog(data []byte) ([]byte, string, error) {
+	d := newDecoder(context.Background(), data)
+	for {
+		offset := d.InputOffset()
+		token, err := d.Token()
+		if err != nil {
+			return nil, "", errors.Wrap(err, "error decoding token")
+		}
+		if _, ok := token.(xml.StartElement); !ok {
+			continue
+		}
+
+		tag := data[offset:d.InputOffset()]
+		end := bytes.IndexAny(tag, " \t\r\n/>")
+		if !bytes.HasPrefix(tag, []byte("<")) || end == -1 {
+			return nil, "", errors.New("unable to find root element")
+		}
+		return data[:d.InputOffset()], string(tag[1:end]), nil
+	}
+}
+
+// byteSpan is a range of bytes, [start, end).
+type byteSpan struct {
+	start int
+	end   int
+}
+
+// itemSpans finds the elements named tag in data, starting at offset from. We
+// match the tag's local name, so a prefixed tag such as <rss:item> counts too.
+//
+// An element runs from its start tag to its end tag. If it is missing its end
+// tag, it runs to the next element's start tag, or to the closing tag of the
+// channel or of the root element (named root), whichever comes first, or
+// otherwise to the end of data. Items don't nest, so this finds them even if
+// they are malformed. We skip CDATA sections and comments, so text in them
+// that looks like a tag doesn't confuse us.
+func itemSpans(data []byte, from int, tag, root string) []byteSpan {
+	root = localTagName([]byte(root))
+
+	var spans []byteSpan
+	start := -1
+	for i := from; i < len(data); {
+		lt := bytes.IndexByte(data[i:], '<')
+		if lt == -1 {
+			break
+		}
+		i += lt
+
+		if skip := skipUnparsed(data[i:]); skip > 0 {
+			i += skip
+			continue
+		}
+
+		closing := i+1 < len(data) && data[i+1] == '/'
+		nameStart := i + 1
+		if closing {
+			nameStart++
+		}
+		nameEnd := len(data)
+		if end := bytes.IndexAny(data[nameStart:], " \t\r\n/>"); end != -1 {
+			nameEnd = nameStart + end
+		}
+		name := localTagName(data[nameStart:nameEnd])
+
+		switch {
+		case !closing && name == tag:
+			if start != -1 {
+				spans = append(spans, byteSpan{start: start, end: i})
+			}
+			start = i
+		case closing && name == tag && start != -1:
+			end := len(data)
+			if gt := bytes.IndexByte(data[nameEnd:], '>'); gt != -1 {
+				end = nameEnd + gt + 1
+			}
+			spans = append(spans, byteSpan{start: start, end: end})
+			start = -1
+			i = end
+			continue
+		case closing && start != -1 && (name == "channel" || name == root):
+			spans = append(spans, byteSpan{start: start, end: i})
+			start = -1
+		}
+
+		i = nameStart
+	}
+
+	if start != -1 {
+		spans = append(spans, byteSpan{start: start, end: len(data)})
+	}
+	return spans
+}
+
+// skipUnparsed returns the length of the CDATA section or comment data starts
+// with, or 0 if it doesn't start with one. If the section is never closed, it
+// runs to the end of data.
+func skipUnparsed(data []byte) int {
+	for _, delims := range [][2]string{{"<![CDATA[", "]]>"}, {"<!--", "-->"}} {
+		if !bytes.HasPrefix(data, []byte(delims[0])) {
+			continue
+		}
+		end := bytes.Index(data[len(delims[0]):], []byte(delims[1]))
+		if end == -1 {
+			return len(data)
+		}
+		return len(delims[0]) + end + len(delims[1])
+	}
+	return 0
+}
+
+// localTagName returns a tag name without its namespace prefix, if any.
+func localTagName(name []byte) string {
+	if i := bytes.IndexByte(name, ':'); i != -1 {
+		name = name[i+1:]
+	}
+	return string(name)
+}
@@ -0,0 +1,147 @@
+package rss
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// sanitizeElements are the elements sanitizeHTML keeps, and the attributes it
+// keeps on each.
+var sanitizeElements = map[atom.Atom][]string{
+	atom.A:          {"href", "title"},
+	atom.P:          nil,
+	atom.Br:         nil,
+	atom.Img:        {"src", "alt", "title", "width", "height"},
+	atom.Strong:     nil,
+	atom.Em:         nil,
+	atom.Ul:         nil,
+	atom.Ol:         nil,
+	atom.Li:         nil,
+	atom.Blockquote: nil,
+	atom.Code:       nil,
+}
+
+// sanitizeURLAttrs are the attributes holding URLs, and the schemes we allow
+// in them.
+var sanitizeURLAttrs = map[string][]string{
+	"href": {"http", "https", "mailto"},
+	"src":  {"http", "https"},
+}
+
+// sanitizeHTML removes everything from the HTML not in our allowlist. See the
+// SanitizeHTML setting.
+//
+// We write out the tokens we keep ourselves rather than as they were, so
+// attribute values are always quoted and escaped. We close any elements left
+// open, and drop end tags for elements that aren't open.
+func sanitizeHTML(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return s
+	}
+
+	var b strings.Builder
+	var open []atom.Atom
+	skipDepth := 0
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tokenType := z.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		token := z.Token()
+		if token.DataAtom == atom.Script || token.DataAtom == atom.Style {
+			if tokenType == html.StartTagToken {
+				skipDepth++
+			}
+			if tokenType == html.EndTagToken && skipDepth > 0 {
+				skipDepth--
+			}
+			continue
+		}
+		if skipDepth > 0 {
+			continue
+		}
+
+		switch tokenType {
+		case html.TextToken:
+			b.WriteString(html.EscapeString(token.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			attrs, ok := sanitizeElements[token.DataAtom]
+			if !ok || isTrackingPixel(token) {
+				continue
+			}
+			// <li> and <p> are closed by the next one, as a browser would.
+			if (token.DataAtom == atom.Li || token.DataAtom == atom.P) &&
+				len(open) > 0 && open[len(open)-1] == token.DataAtom {
+				b.WriteString("</" + token.DataAtom.String() + ">")
+				open = open[:len(open)-1]
+			}
+			writeSanitizedTag(&b, token, attrs)
+			if tokenType == html.StartTagToken && !isVoidElement(token.DataAtom) {
+				open = append(open, token.DataAtom)
+			}
+		case html.EndTagToken:
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != token.DataAtom {
+					continue
+				}
+				// Close this element and any opened inside it.
+				for j := len(open) - 1; j >= i; j-- {
+					b.WriteString("</" + open[j].String() + ">")
+				}
+				open = open[:i]
+				break
+			}
+		}
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i].String() + ">")
+	}
+
+	return b.String()
+}
+
+// writeSanitizedTag writes the start tag with only the allowed attributes.
+// We drop URL attributes with a scheme we don't allow.
+func writeSanitizedTag(b *strings.Builder, token html.Token, allowed []string) {
+	b.WriteString("<" + token.DataAtom.String())
+	for _, attr := range token.Attr {
+		if attr.Namespace != "" || !containsString(allowed, attr.Key) {
+			continue
+		}
+		if schemes, ok := sanitizeURLAttrs[attr.Key]; ok &&
+			!hasSchemeIn(attr.Val, schemes) {
+			continue
+		}
+		b.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+	}
+	b.WriteString(">")
+}
+
+// isTrackingPixel reports whether the token is an image 1 pixel or smaller in
+// either dimension.
+func isTrackingPixel(token html.Token) bool {
+	if token.DataAtom != atom.Img {
+		return false
+	}
+	for _, attr := range token.Attr {
+		if attr.Key != "width" && attr.Key != "height" {
+			continue
+		}
+		if v := strings.TrimSpace(strings.TrimSuffix(attr.Val, "px")); v == "0" ||
+			v == "1" {
+			return true
+		}
+	}
+	return false
+}
+
+// isVoidElement reports whether the element has no end tag.
+func isVoidElement(a atom.Atom) bool {
+	return a == atom.Br || a == atom.Img
+}
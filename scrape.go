@@ -0,0 +1,104 @@
+package rss
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// scrapeHTML builds a Feed from an HTML page. This is a last resort for when a
+// document is not a feed at all. See the Scrape setting.
+//
+// The feed's title comes from <title>, or the first <h1> if there is no
+// <title>. There is an item for each <a href>, titled with the link's text.
+func scrapeHTML(data []byte) (*Feed, error) {
+	r, err := charset.NewReader(bytes.NewReader(data), "")
+	if err != nil {
+		return nil, errors.Wrap(err, "error determining charset")
+	}
+
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing HTML")
+	}
+
+	feed := &Feed{
+		Type:     "HTML",
+		FeedType: FeedHTML,
+		Warnings: []string{
+			"document is not a feed, so we scraped its title and links from HTML",
+		},
+	}
+
+	var h1 string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Title:
+				if feed.Title == "" {
+					feed.Title = nodeText(n)
+				}
+			case atom.H1:
+				if h1 == "" {
+					h1 = nodeText(n)
+				}
+			case atom.A:
+				href := strings.TrimSpace(attrValue(n, "href"))
+				if href != "" && !strings.HasPrefix(href, "#") {
+					feed.Items = append(feed.Items, Item{
+						Title: nodeText(n),
+						Link:  href,
+					})
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if feed.Title == "" {
+		feed.Title = h1
+	}
+
+	if feed.Title == "" && len(feed.Items) == 0 {
+		return nil, errors.New("no title or links found in HTML")
+	}
+
+	return feed, nil
+}
+
+// nodeText returns the text inside an HTML node with whitespace collapsed.
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// attrValue returns the value of an HTML node's attribute, or blank if it
+// doesn't have it.
+func attrValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && strings.EqualFold(attr.Key, key) {
+			return attr.Val
+		}
+	}
+	return ""
+}
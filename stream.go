@@ -0,0 +1,204 @@
+package rss
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html/charset"
+)
+
+// ErrStopStream is an error a ParseFeedStream() callback can return to stop
+// parsing. ParseFeedStream() then returns the feed without an error.
+var ErrStopStream = errors.New("stop streaming feed")
+
+// ParseFeedStream parses an RSS, RDF, or Atom feed from r. Unlike
+// ParseFeedXML, we don't collect the items in Feed.Items. Instead we call fn
+// with each item as we decode it. This means we don't need to hold the whole
+// document or all of its items in memory, which matters for huge feeds.
+//
+// The feed we return holds the feed's metadata. Items are processed as
+// ParseFeedXML would, except they only see metadata that comes before them in
+// the document. For example, with the InheritAuthor setting, an item only
+// inherits an author declared before it. Feeds usually put their metadata
+// first, so this rarely makes a difference.
+//
+// If fn returns ErrStopStream, we stop reading and return the metadata we've
+// seen so far. If it returns any other error, we stop and return that error.
+//
+// As we read the document as we go, we don't replace invalid UTF-8, we don't
+// support JSON Feed, and we don't scrape HTML.
+func ParseFeedStream(r io.Reader, fn func(Item) error) (*Feed, error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = charset.NewReaderLabel
+	d.DefaultSpace = "default"
+
+	root, err := rootElement(d)
+	if err != nil {
+		return nil, err
+	}
+
+	feedType, err := feedTypeOfRoot(root.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, newFeed, decodeItem := formatDecoders(feedType)
+	s := &itemStream{
+		d:          d,
+		root:       &root,
+		itemDepth:  1,
+		itemName:   itemElement(feedType),
+		newFeed:    newFeed,
+		decodeItem: decodeItem,
+		fn:         fn,
+	}
+	if feedType == "RSS" {
+		// Items are in the <channel>.
+		s.itemDepth = 2
+	}
+
+	// Decode the metadata with the parsers' own structs. The stream leaves out
+	// the items, so they stay empty.
+	err = xml.NewTokenDecoder(s).Decode(doc)
+
+	if s.err != nil && s.err != ErrStopStream {
+		return nil, s.err
+	}
+	if err != nil && s.err == nil {
+		return nil, fmt.Errorf("%s XML decode error: %v", feedType, err)
+	}
+
+	feed := s.newFeed()
+
+	if config.Verbose {
+		log.Printf("Parsed channel as %s [%s]", feedType, feed.Title)
+	}
+
+	if s.feed != nil {
+		feed.Warnings = append(feed.Warnings, s.feed.Warnings[s.warnings:]...)
+	}
+	warnSkipped(feed, s.skipped)
+
+	finishFeed(feed)
+	resolveRelativeURLs(feed, "")
+
+	return feed, nil
+}
+
+// rootElement reads tokens until the document's root element and returns it.
+func rootElement(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return xml.StartElement{}, errors.New("document has no root element")
+			}
+			return xml.StartElement{}, errors.Wrap(err, "error decoding token")
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Copy(), nil
+		}
+	}
+}
+
+// itemStream is an xml.TokenReader that passes through a feed's tokens
+// except for its items. It decodes each item itself as it comes to it and
+// calls the ParseFeedStream() callback with it.
+type itemStream struct {
+	d *xml.Decoder
+
+	// root is the root element. rootElement() already read it, so we return
+	// it first.
+	root *xml.StartElement
+
+	// depth is how many elements are open.
+	depth int
+
+	// Items are the elements named itemName itemDepth elements deep.
+	itemDepth int
+	itemName  string
+
+	// newFeed builds a feed from the metadata decoded so far.
+	newFeed func() *Feed
+
+	decodeItem itemDecoder
+
+	fn func(Item) error
+
+	// feed is the feed we give items when processing them. We build it when we
+	// come to the first item. warnings is how many warnings it had then. Any
+	// more come from items.
+	feed     *Feed
+	warnings int
+
+	// skipped counts empty items we skipped.
+	skipped int
+
+	// err is the error fn returned, if any.
+	err error
+}
+
+// Token returns the next token that is not part of an item.
+func (s *itemStream) Token() (xml.Token, error) {
+	if s.root != nil {
+		root := *s.root
+		s.root = nil
+		s.depth++
+		return root, nil
+	}
+
+	for {
+		token, err := s.d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if s.depth == s.itemDepth && t.Name.Local == s.itemName {
+				if err := s.item(&t); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			s.depth++
+		case xml.EndElement:
+			s.depth--
+		}
+
+		return xml.CopyToken(token), nil
+	}
+}
+
+// item decodes and processes the item starting at start, then calls fn with
+// it.
+func (s *itemStream) item(start *xml.StartElement) error {
+	if s.feed == nil {
+		s.feed = s.newFeed()
+		s.warnings = len(s.feed.Warnings)
+	}
+
+	item, err := s.decodeItem(s.feed, s.d, start)
+	if err != nil {
+		return err
+	}
+
+	if config.SkipEmptyItems && isEmptyItem(item) {
+		s.skipped++
+		return nil
+	}
+
+	finishItem(s.feed, &item)
+	if base := feedBaseURL(s.feed, ""); base != "" {
+		resolveItemURLs(&item, base)
+	}
+
+	if err := s.fn(item); err != nil {
+		s.err = err
+		return err
+	}
+	return nil
+}
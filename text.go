@@ -0,0 +1,94 @@
+package rss
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// inlineElements are HTML elements that don't separate words. When we convert
+// HTML to plain text, we add a space where other elements start and end.
+var inlineElements = map[atom.Atom]bool{
+	atom.A:      true,
+	atom.Abbr:   true,
+	atom.B:      true,
+	atom.Cite:   true,
+	atom.Code:   true,
+	atom.Em:     true,
+	atom.I:      true,
+	atom.Mark:   true,
+	atom.Q:      true,
+	atom.S:      true,
+	atom.Small:  true,
+	atom.Span:   true,
+	atom.Strong: true,
+	atom.Sub:    true,
+	atom.Sup:    true,
+	atom.U:      true,
+}
+
+// plaintext converts HTML, such as an item's description, to plain text. It
+// removes tags, decodes entities, drops the contents of <script> and <style>,
+// and collapses whitespace.
+func plaintext(s string) string {
+	var b strings.Builder
+	skipDepth := 0
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tokenType := z.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		token := z.Token()
+		switch tokenType {
+		case html.TextToken:
+			if skipDepth == 0 {
+				b.WriteString(token.Data)
+			}
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			if token.DataAtom == atom.Script || token.DataAtom == atom.Style {
+				if tokenType == html.StartTagToken {
+					skipDepth++
+				}
+				if tokenType == html.EndTagToken && skipDepth > 0 {
+					skipDepth--
+				}
+			}
+			if !inlineElements[token.DataAtom] {
+				b.WriteString(" ")
+			}
+		}
+	}
+
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// collapseSpace removes surrounding whitespace and replaces runs of whitespace
+// with one space.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// excerpt converts HTML to plain text and shortens it to at most max bytes.
+// If we shorten it, we cut at the last space we can so we don't split a word,
+// and add an ellipsis. The ellipsis counts towards max.
+func excerpt(s string, max int) string {
+	text := plaintext(s)
+	if len(text) <= max {
+		return text
+	}
+
+	const ellipsis = "…"
+	if max < len(ellipsis) {
+		return truncateUTF8(text, max)
+	}
+
+	text = truncateUTF8(text, max-len(ellipsis))
+	if space := strings.LastIndex(text, " "); space > 0 {
+		text = text[:space]
+	}
+	return strings.TrimRight(text, " ,.;:") + ellipsis
+}
@@ -0,0 +1,119 @@
+package rss
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Validate checks the feed meets the RSS 2.0 requirements. It returns an error
+// describing each problem it finds. If it returns none, the feed is valid.
+//
+// The channel must have a title, link, and description, and each item must
+// have a title or a description.
+func (f Feed) Validate() []error {
+	var errs []error
+
+	if strings.TrimSpace(f.Title) == "" {
+		errs = append(errs, fmt.Errorf("channel has no title"))
+	}
+	if strings.TrimSpace(f.Link) == "" {
+		errs = append(errs, fmt.Errorf("channel has no link"))
+	}
+	if strings.TrimSpace(f.Description) == "" {
+		errs = append(errs, fmt.Errorf("channel has no description"))
+	}
+
+	for i, item := range f.Items {
+		if strings.TrimSpace(item.Title) == "" &&
+			strings.TrimSpace(item.Description) == "" {
+			errs = append(errs, fmt.Errorf("item %d has no title or description",
+				i))
+		}
+	}
+
+	return errs
+}
+
+// validateStrict checks the feed meets everything the StrictRFC setting
+// enforces. See that setting for what this is.
+func validateStrict(feed Feed) error {
+	errs := feed.Validate()
+
+	for i, item := range feed.Items {
+		if guid, _ := itemGUID(item); guid == "" {
+			errs = append(errs, fmt.Errorf("item %d has no guid", i))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("feed is not valid: %s", strings.Join(msgs, ", "))
+}
+
+// LinkIssue describes a problem with an item's link. See CheckLinks().
+type LinkIssue struct {
+	// Index is the position of the item in Items.
+	Index int
+
+	Title string
+	Link  string
+
+	// Problem says what is wrong, such as "link is relative".
+	Problem string
+}
+
+// CheckLinks reports items whose links are not absolute URLs. That is, links
+// that are blank, relative, or don't parse. This is useful to catch mistakes
+// in feeds you generate. It doesn't make any network requests, so it can't
+// tell whether the links work.
+//
+// If every link is fine it returns an empty slice, not nil.
+func (f *Feed) CheckLinks() []LinkIssue {
+	issues := []LinkIssue{}
+	for i, item := range f.Items {
+		problem := linkProblem(item.Link)
+		if problem == "" {
+			continue
+		}
+		issues = append(issues, LinkIssue{
+			Index:   i,
+			Title:   item.Title,
+			Link:    item.Link,
+			Problem: problem,
+		})
+	}
+	return issues
+}
+
+// linkProblem returns what is wrong with the link, or blank if it is an
+// absolute URL.
+func linkProblem(link string) string {
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return "link is blank"
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return fmt.Sprintf("link is malformed: %s", err)
+	}
+
+	if !u.IsAbs() {
+		return "link is relative"
+	}
+
+	// Schemes like mailto: have no host but are still absolute. Otherwise
+	// we need one.
+	if u.Opaque == "" && u.Host == "" {
+		return "link has no host"
+	}
+
+	return ""
+}